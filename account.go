@@ -0,0 +1,165 @@
+package main
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"time"
+)
+
+// passwords are 8 to 72 bytes, bcrypt ignores the rest
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// Account is a registered player or the identity behind a token, the
+// rating, the mute and the blocks of its users follow it across devices.
+// Accounts of tokens have no password.
+type Account struct {
+	id           string
+	username     string
+	rating       int
+	passwordHash string
+	createdAt    time.Time
+	mutedUntil   time.Time
+	// blocked are the identities the account blocked, see User.identity
+	blocked []string
+}
+
+func (u *User) account() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.accountID
+}
+
+// identity names the player behind the user in blocks: its account, or
+// the session of a guest
+func (u *User) identity() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.accountID != "" {
+		return u.accountID
+	}
+	return u.uuid
+}
+
+// sameAccount tells whether two sessions of an account are the users
+func sameAccount(first, second *User) bool {
+	accountID := first.account()
+	return accountID != "" && accountID == second.account()
+}
+
+// saveAccount copies the username, the rating, the mute and the blocks of
+// an authenticated user to its account
+func (u *User) saveAccount() {
+	accountID := u.account()
+	if accountID == "" {
+		return
+	}
+	account := u.repository.AccountByID(accountID)
+	if account == nil {
+		return
+	}
+	updated := *account
+	updated.username = u.name()
+	updated.rating = u.currentRating()
+	updated.mutedUntil = u.mutedTill(time.Now())
+	updated.blocked = u.blockedUsers()
+	u.repository.SaveAccount(&updated)
+}
+
+// loginPayload describes the session of a logged in user, players of
+// accounts get a new token with withToken
+func (u *User) loginPayload(withToken bool) map[string]string {
+	payload := map[string]string{
+		"uuid":           u.uuid,
+		"username":       u.name(),
+		"reconnectToken": u.reconnectToken,
+	}
+	account := u.repository.AccountByID(u.account())
+	if account == nil {
+		return payload
+	}
+	payload["accountID"] = account.id
+	payload["rating"] = strconv.Itoa(u.currentRating())
+	if withToken {
+		if token := issueToken(account, time.Now()); token != "" {
+			payload["token"] = token
+		}
+	}
+	return payload
+}
+
+// authenticate finds the account of a Login payload: the account of the
+// username and password, of the token or of the token of the socket.
+// The account of a new token isn't saved yet. Guests have no account.
+func (u *User) authenticate(payload map[string]string) (*Account, error) {
+	if password, ok := payload["password"]; ok {
+		account := u.repository.AccountByUsername(payload["username"])
+		if account == nil || account.passwordHash == "" ||
+			bcrypt.CompareHashAndPassword([]byte(account.passwordHash), []byte(password)) != nil {
+			return nil, errInvalidCredentials
+		}
+		return account, nil
+	}
+
+	claims := u.claims
+	if token := payload["token"]; token != "" {
+		var err error
+		claims, err = ParseToken(authSecret, token, time.Now())
+		if err != nil {
+			return nil, errInvalidAuthToken
+		}
+	}
+	if claims == nil {
+		return nil, nil
+	}
+	if account := u.repository.AccountByID(claims.Subject); account != nil {
+		return account, nil
+	}
+	return &Account{claims.Subject, claims.Name, defaultRating, "", time.Now(), time.Time{}, nil}, nil
+}
+
+// register creates an account with a password and logs the user into it.
+// A guest keeps its session, rating and game, its name is the default
+// username.
+func (u *User) register(username, password string) (*Account, error) {
+	if u.account() != "" {
+		return nil, errAlreadyRegistered
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, errInvalidPassword
+	}
+	guest := u.repository.UserByUUID(u.uuid) != nil
+	if username == "" && guest {
+		username = u.name()
+	}
+	err := validateUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	usernamesMutex.Lock()
+	defer usernamesMutex.Unlock()
+	if usernameTaken(u.repository, username, u, "") {
+		return nil, errUsernameTaken
+	}
+	account := &Account{generateUUID(), username, u.currentRating(), string(hash), time.Now(), u.mutedTill(time.Now()), u.blockedUsers()}
+	u.mutex.Lock()
+	u.username = username
+	u.accountID = account.id
+	u.mutex.Unlock()
+	if u.reconnectToken == "" {
+		u.reconnectToken = generateToken()
+	}
+	if !guest {
+		u.repository.AddUser(u)
+	}
+	u.repository.SaveUser(u)
+	u.repository.SaveAccount(account)
+	return account, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUser_Register(t *testing.T) {
+	defer func(secret []byte) { authSecret = secret }(authSecret)
+	authSecret = []byte("secret")
+
+	repository := InmemoryRepository()
+	guest, opponent := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	for i, user := range []*User{guest, opponent} {
+		user.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": []string{"guest", "opponent"}[i]}})
+		<-user.writeChan
+	}
+	startGame(repository, guest, opponent, DefaultGameVariant)
+	for _, user := range []*User{guest, opponent} {
+		<-user.writeChan
+		<-user.writeChan
+	}
+	gameUUID := guest.gameUUID()
+
+	guest.resolveMessage(Message{Type: Register, Payload: map[string]string{"password": "short"}})
+	if gotMsg := <-guest.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "INVALID_PASSWORD" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	guest.resolveMessage(Message{ID: "register", Type: Register, Payload: map[string]string{"password": "correct horse"}})
+	gotMsg := <-guest.writeChan
+	if gotMsg.ID != "register" || gotMsg.Type != RegisterSuccess || gotMsg.Payload["uuid"] != guest.uuid ||
+		gotMsg.Payload["username"] != "guest" || gotMsg.Payload["accountID"] == "" {
+		t.Fatalf("invalid write message %v", gotMsg)
+	}
+	if claims, err := ParseToken(authSecret, gotMsg.Payload["token"], time.Now()); err != nil || claims.Subject != gotMsg.Payload["accountID"] {
+		t.Errorf("invalid token %v: %v", claims, err)
+	}
+	accountID := gotMsg.Payload["accountID"]
+	// the guest keeps playing
+	if guest.gameUUID() != gameUUID {
+		t.Errorf("game = %v, want %v", guest.gameUUID(), gameUUID)
+	}
+	if err := repository.GameByUUID(gameUUID).Move(guest, 5); err != nil {
+		t.Errorf("move after Register: %v", err)
+	}
+	guest.resolveMessage(Message{Type: Register, Payload: map[string]string{"username": "other", "password": "correct horse"}})
+	if gotMsg := readUntilChan(t, guest, Error); gotMsg.Payload["code"] != "ALREADY_REGISTERED" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+
+	// the account logs in from another device, guests can't take its name
+	device := MockUserWithRepository(repository)
+	device.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "GUEST", "password": "wrong password"}})
+	if gotMsg := <-device.writeChan; gotMsg.Type != LoginFailed || gotMsg.Payload["reason"] != "INVALID_CREDENTIALS" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	guest.close()
+	device.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "Guest"}})
+	if gotMsg := <-device.writeChan; gotMsg.Type != LoginFailed || gotMsg.Payload["reason"] != "USERNAME_TAKEN" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	device.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "GUEST", "password": "correct horse"}})
+	gotMsg = <-device.writeChan
+	if gotMsg.Type != LoginSuccess || gotMsg.Payload["username"] != "guest" || gotMsg.Payload["accountID"] != accountID ||
+		gotMsg.Payload["token"] == "" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+
+	// Register logs new users in
+	newcomer := MockUserWithRepository(repository)
+	newcomer.resolveMessage(Message{Type: Register, Payload: map[string]string{"username": "newcomer", "password": "correct horse"}})
+	if gotMsg := <-newcomer.writeChan; gotMsg.Type != RegisterSuccess || repository.UserByUUID(newcomer.uuid) == nil {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
+
+// readUntilChan skips messages of the user until one of messageType arrives
+func readUntilChan(t *testing.T, user *User, messageType string) Message {
+	for {
+		select {
+		case message := <-user.writeChan:
+			if message.Type == messageType {
+				return message
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("waiting for %v", messageType)
+		}
+	}
+}
+
+func TestMessage_String(t *testing.T) {
+	message := Message{"login", LoginSuccess, map[string]string{
+		"username":       "user",
+		"password":       "password1",
+		"token":          "header.claims.signature",
+		"reconnectToken": "resume-secret",
+		"key":            "admin-secret",
+	}}
+	got := message.String()
+	for _, secret := range []string{"password1", "header.claims.signature", "resume-secret", "admin-secret"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("String() = %v, reveals %v", got, secret)
+		}
+	}
+	if !strings.Contains(got, "username:user") {
+		t.Errorf("String() = %v, want the username", got)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authSecret signs the bearer tokens, AUTH_SECRET sets it. Tokens are
+// rejected while it's empty and players can only log in as guests.
+var authSecret []byte
+
+// maxAccountIDLength bounds the sub claim
+const maxAccountIDLength = 128
+
+// Claims are the JWT claims the server reads, the subject is the account ID
+type Claims struct {
+	Subject   string `json:"sub"`
+	Name      string `json:"name,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// tokenHeader is the only JWT header accepted, HMAC SHA-256
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+var (
+	errMalformedToken = errors.New("malformed token")
+	errTokenSignature = errors.New("invalid token signature")
+	errTokenExpired   = errors.New("token expired")
+)
+
+func signature(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// SignToken issues a HS256 JWT with the claims
+func SignToken(secret []byte, claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature(secret, signed)), nil
+}
+
+// ParseToken verifies a HS256 JWT and returns its claims, tokens without
+// the exp claim don't expire
+func ParseToken(secret []byte, token string, now time.Time) (*Claims, error) {
+	if len(secret) == 0 {
+		return nil, errTokenSignature
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var fields struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &fields) != nil || fields.Alg != "HS256" {
+		return nil, errMalformedToken
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sum, signature(secret, parts[0]+"."+parts[1])) {
+		return nil, errTokenSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	claims := &Claims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil || claims.Subject == "" || len(claims.Subject) > maxAccountIDLength {
+		return nil, errMalformedToken
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	return claims, nil
+}
+
+// requestToken returns the bearer token of a websocket upgrade request,
+// sent in the Authorization header or the token query parameter
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return r.URL.Query().Get("token")
+}
+
+// tokenTTL is the lifetime of the tokens issued on Register and password login
+const tokenTTL = 30 * 24 * time.Hour
+
+// issueToken signs a token of the account, "" when tokens are disabled
+func issueToken(account *Account, now time.Time) string {
+	if len(authSecret) == 0 {
+		return ""
+	}
+	token, err := SignToken(authSecret, Claims{account.id, account.username, now.Unix(), now.Add(tokenTTL).Unix()})
+	if err != nil {
+		log.Printf("error: %v", err)
+		return ""
+	}
+	return token
+}
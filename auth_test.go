@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1500000000, 0)
+	sign := func(claims Claims) string {
+		token, err := SignToken(secret, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(Claims{Subject: "account", ExpiresAt: now.Unix() + 60})
+	parts := strings.Split(valid, ".")
+	other := strings.Split(sign(Claims{Subject: "other"}), ".")
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", valid, nil},
+		{"no expiry", sign(Claims{Subject: "account"}), nil},
+		{"expired", sign(Claims{Subject: "account", ExpiresAt: now.Unix()}), errTokenExpired},
+		{"no subject", sign(Claims{Name: "gopher"}), errMalformedToken},
+		{"tampered", parts[0] + "." + other[1] + "." + parts[2], errTokenSignature},
+		{"unsigned", "eyJhbGciOiJub25lIn0." + parts[1] + ".", errMalformedToken},
+		{"truncated", parts[0] + "." + parts[1], errMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseToken(secret, tt.token, now)
+			if err != tt.want {
+				t.Fatalf("ParseToken() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && claims.Subject != "account" {
+				t.Errorf("ParseToken() subject = %v, want account", claims.Subject)
+			}
+		})
+	}
+	if _, err := ParseToken([]byte("other"), valid, now); err != errTokenSignature {
+		t.Errorf("ParseToken() with another secret = %v, want %v", err, errTokenSignature)
+	}
+}
+
+func TestUser_TokenLogin(t *testing.T) {
+	defer func(secret []byte) { authSecret = secret }(authSecret)
+	authSecret = []byte("secret")
+	token, _ := SignToken(authSecret, Claims{Subject: "account"})
+
+	repository := InmemoryRepository()
+	phone := MockUserWithRepository(repository)
+	phone.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "gopher", "token": "bad"}})
+	if gotMsg := <-phone.writeChan; gotMsg.Type != LoginFailed || gotMsg.Payload["reason"] != "INVALID_AUTH_TOKEN" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	phone.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "gopher", "token": token}})
+	if gotMsg := <-phone.writeChan; gotMsg.Type != LoginSuccess || gotMsg.Payload["accountID"] != "account" ||
+		gotMsg.Payload["rating"] != "1200" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	phone.setRating(1300)
+	phone.saveAccount()
+	phone.close()
+
+	// the rating and the name follow the account to another device
+	tablet := MockUserWithRepository(repository)
+	tablet.resolveMessage(Message{Type: Login, Payload: map[string]string{"token": token}})
+	gotMsg := <-tablet.writeChan
+	if gotMsg.Type != LoginSuccess || gotMsg.Payload["username"] != "gopher" || gotMsg.Payload["rating"] != "1300" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
+
+func TestHandleWebsocketConnections_Token(t *testing.T) {
+	defer func(secret []byte) { authSecret = secret }(authSecret)
+	authSecret = []byte("secret")
+	token, _ := SignToken(authSecret, Claims{Subject: "socket-account", Name: "socket"})
+
+	Repository = InmemoryRepository()
+	server := httptest.NewServer(http.HandlerFunc(handleWebsocketConnections))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, response, err := websocket.DefaultDialer.Dial(url+"?token=bad", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("connection with a bad token = %v, want %v", err, http.StatusUnauthorized)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.WriteJSON(Message{Type: Login, Payload: map[string]string{}})
+	if gotMsg := readUntil(t, ws, LoginSuccess); gotMsg.Payload["username"] != "socket" || gotMsg.Payload["accountID"] != "socket-account" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
+
+func TestHandleWebsocketConnections_PlainHTTP(t *testing.T) {
+	Repository = InmemoryRepository()
+	recorder := httptest.NewRecorder()
+	handleWebsocketConnections(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("plain GET / = %v, want %v", recorder.Code, http.StatusBadRequest)
+	}
+	if users := Repository.Users(); len(users) != 0 {
+		t.Errorf("users = %v, want 0", users)
+	}
+}
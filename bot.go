@@ -0,0 +1,223 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	BotEasy    = "easy"
+	BotMedium  = "medium"
+	BotPerfect = "perfect"
+)
+
+// botDepths limits the search depth of each difficulty, 0 searches until
+// the end of the game or until botNodeBudget runs out
+var botDepths = map[string]int{
+	BotEasy:    1,
+	BotMedium:  3,
+	BotPerfect: 0,
+}
+
+const (
+	// botBlunderChance is the share of random moves made on the easy level
+	botBlunderChance = 0.3
+	// botNodeBudget bounds the positions searched for a single move,
+	// large boards fall back to the deepest completed search
+	botNodeBudget = 50000
+	// botWinScore exceeds any search depth, faster wins score higher
+	botWinScore = 1000
+	// botQueueSize covers everything a game sends while the bot moves
+	botQueueSize = 16
+)
+
+// botWait is how long a player searches alone before a bot is matched,
+// zero disables bots
+var botWait = 30 * time.Second
+
+var botDifficulty = BotMedium
+
+// Bot is a server side player. Its User has no socket: the bot reads
+// what the game sends to writeChan and answers through resolveMessage.
+type Bot struct {
+	user       *User
+	difficulty string
+	rules      Rules
+	unit       GameUnit
+	random     *rand.Rand
+	nodes      int
+}
+
+func NewBot(repository IRepository, variant GameVariant, difficulty string) *Bot {
+	user := NewUser(repository)
+	user.username = "bot (" + difficulty + ")"
+	user.writeChan = make(chan Message, botQueueSize)
+	user.bot = true
+	return &Bot{
+		user,
+		difficulty,
+		variant.Rules(),
+		EMPTY,
+		rand.New(rand.NewSource(time.Now().UnixNano())),
+		0,
+	}
+}
+
+// run plays the game until it is over. Moves are searched on their own
+// goroutine: the game waits for the bot to read what it sends, so run keeps
+// reading while the bot thinks and submits the move.
+func (b *Bot) run() {
+	for message := range b.user.writeChan {
+		switch message.Type {
+		case GameSearchStart:
+			b.unit = ZERO
+			if message.Payload["crossUserUUID"] == b.user.uuid {
+				b.unit = CROSS
+				go b.move(b.rules.NewField())
+			}
+
+		case GameMoved:
+			field := GameField{}
+			for key, value := range message.Payload {
+				position, err := strconv.Atoi(key)
+				if err != nil {
+					continue
+				}
+				field[position] = GameUnit(value)
+			}
+			if _, over := b.rules.Outcome(field); !over && turnOf(field) == b.unit {
+				go b.move(field)
+			}
+
+		case GameWinner, GameDraw, GameOver:
+			// nobody reads writeChan anymore, let send drop the rest
+			b.user.mutex.Lock()
+			b.user.detached = true
+			b.user.mutex.Unlock()
+			return
+
+		case Error:
+			log.Printf("bot %v move rejected: %v", b.user.uuid, message.Payload)
+		}
+	}
+}
+
+func (b *Bot) move(field GameField) {
+	position := b.bestMove(field)
+	if position == 0 {
+		return
+	}
+	b.user.resolveMessage(Message{
+		Type:    GameMove,
+		Payload: map[string]string{"position": strconv.Itoa(position)},
+	})
+}
+
+// bestMove returns the position to move to, 0 when there are no moves
+func (b *Bot) bestMove(field GameField) int {
+	moves := b.moves(field, b.unit)
+	if len(moves) == 0 {
+		return 0
+	}
+	if b.difficulty == BotEasy && b.random.Float64() < botBlunderChance {
+		return moves[b.random.Intn(len(moves))]
+	}
+	maxDepth := botDepths[b.difficulty]
+	if maxDepth == 0 {
+		maxDepth = len(field)
+	}
+
+	// iterative deepening keeps the last search that fit into the budget
+	best := moves
+	b.nodes = 0
+	for depth := 1; depth <= maxDepth; depth++ {
+		var candidates []int
+		bestScore := -2 * botWinScore
+		for _, move := range moves {
+			cell := b.rules.ApplyMove(field, b.unit, move)
+			score := -b.negamax(field, opponentOf(b.unit), depth-1, -2*botWinScore, 2*botWinScore)
+			field[cell] = EMPTY
+			if score > bestScore {
+				bestScore = score
+				candidates = candidates[:0]
+			}
+			if score == bestScore {
+				candidates = append(candidates, move)
+			}
+		}
+		if b.nodes > botNodeBudget {
+			break
+		}
+		best = candidates
+		if bestScore >= botWinScore || bestScore <= -botWinScore {
+			// the result is known, deeper searches won't change it
+			break
+		}
+	}
+	return best[b.random.Intn(len(best))]
+}
+
+// negamax scores field for unit which is about to move using
+// minimax with alpha-beta pruning, positive scores are good for unit
+func (b *Bot) negamax(field GameField, unit GameUnit, depth, alpha, beta int) int {
+	b.nodes++
+	if winner, over := b.rules.Outcome(field); over {
+		if winner == EMPTY {
+			return 0
+		}
+		// the opponent has just made the winning move
+		return -(botWinScore + depth)
+	}
+	if depth == 0 || b.nodes > botNodeBudget {
+		return 0
+	}
+	for _, move := range b.moves(field, unit) {
+		cell := b.rules.ApplyMove(field, unit, move)
+		score := -b.negamax(field, opponentOf(unit), depth-1, -beta, -alpha)
+		field[cell] = EMPTY
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return alpha
+}
+
+// moves lists the positions unit is allowed to move to
+func (b *Bot) moves(field GameField, unit GameUnit) []int {
+	var moves []int
+	for position := 1; position <= len(field); position++ {
+		if b.rules.ValidateMove(field, unit, position) == nil {
+			moves = append(moves, position)
+		}
+	}
+	return moves
+}
+
+// turnOf returns the unit about to move, crosses always move first
+func turnOf(field GameField) GameUnit {
+	crosses, zeros := 0, 0
+	for _, unit := range field {
+		switch unit {
+		case CROSS:
+			crosses++
+		case ZERO:
+			zeros++
+		}
+	}
+	if crosses > zeros {
+		return ZERO
+	}
+	return CROSS
+}
+
+func opponentOf(unit GameUnit) GameUnit {
+	if unit == CROSS {
+		return ZERO
+	}
+	return CROSS
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBot_bestMove(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant GameVariant
+		unit    GameUnit
+		field   map[int]GameUnit
+		want    int
+	}{
+		{
+			"takes the win",
+			DefaultGameVariant,
+			CROSS,
+			map[int]GameUnit{1: CROSS, 2: CROSS, 4: ZERO, 5: ZERO},
+			3,
+		},
+		{
+			"blocks the opponent",
+			DefaultGameVariant,
+			ZERO,
+			map[int]GameUnit{1: CROSS, 5: ZERO, 7: CROSS},
+			4,
+		},
+		{
+			"drops into the winning column",
+			rulesDefaults[ConnectFour],
+			CROSS,
+			map[int]GameUnit{36: CROSS, 37: CROSS, 38: CROSS, 29: ZERO, 30: ZERO, 31: ZERO},
+			4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, difficulty := range []string{BotMedium, BotPerfect} {
+				bot := NewBot(nil, tt.variant, difficulty)
+				bot.unit = tt.unit
+				field := bot.rules.NewField()
+				for position, unit := range tt.field {
+					field[position] = unit
+				}
+				if got := bot.bestMove(field); got != tt.want {
+					t.Errorf("%v bot bestMove() = %v, want %v", difficulty, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBot_PerfectPlayDraws(t *testing.T) {
+	repository := InmemoryRepository()
+	crossBot := NewBot(repository, DefaultGameVariant, BotPerfect)
+	zeroBot := NewBot(repository, DefaultGameVariant, BotPerfect)
+	go crossBot.run()
+	go zeroBot.run()
+
+	startGame(repository, crossBot.user, zeroBot.user, DefaultGameVariant)
+	var game *Game
+	for _, game = range repository.GameSessions() {
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !game.IsOver() {
+		if time.Now().After(deadline) {
+			t.Fatal("bots didn't finish the game")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var draw bool
+	game.do(func() {
+		draw = game.CheckDraw()
+	})
+	if !draw {
+		t.Errorf("perfect bots should draw, got field %v", game.field)
+	}
+}
+
+func TestBot_KeepsReading(t *testing.T) {
+	repository := InmemoryRepository()
+	variant := DefaultGameVariant
+	variant.width, variant.height, variant.winLength = maxBoardSize, maxBoardSize, 5
+	player, spectator := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	repository.AddUser(player)
+	repository.AddUser(spectator)
+	bot := NewBot(repository, variant, BotPerfect)
+	go bot.run()
+	startGame(repository, player, bot.user, variant)
+	game := repository.GameByUUID(player.gameUUID())
+	readUntilChan(t, player, GameSearchStart)
+	for _, user := range []*User{player, spectator} {
+		go func(user *User) {
+			for range user.writeChan {
+			}
+		}(user)
+	}
+	if err := game.Move(player, 113); err != nil {
+		t.Fatal(err)
+	}
+
+	moved := make(chan struct{})
+	go func() {
+		// the game sends more than the queue of the bot holds while it thinks
+		for i := 0; i < botQueueSize; i++ {
+			game.Watch(spectator, "")
+			game.Unwatch(spectator)
+		}
+		for moves := 0; moves < 2; time.Sleep(10 * time.Millisecond) {
+			game.do(func() {
+				moves = len(game.moves)
+			})
+		}
+		close(moved)
+	}()
+	select {
+	case <-moved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the bot blocked its game")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// challengeTTL is how long a challenge waits for the answer
+var challengeTTL = time.Minute
+
+// Challenge is a game offered to a specific online user
+type Challenge struct {
+	id             string
+	challengerUUID string
+	challengedUUID string
+	variant        GameVariant
+	expiresAt      time.Time
+}
+
+func NewChallenge(challenger, challenged *User, variant GameVariant, now time.Time) *Challenge {
+	return &Challenge{
+		generateUUID(),
+		challenger.uuid,
+		challenged.uuid,
+		variant,
+		now.Add(challengeTTL),
+	}
+}
+
+func (c *Challenge) Expired(now time.Time) bool {
+	return !now.Before(c.expiresAt)
+}
+
+// other returns the user of the challenge who isn't userUUID
+func (c *Challenge) other(userUUID string) string {
+	if c.challengerUUID == userUUID {
+		return c.challengedUUID
+	}
+	return c.challengerUUID
+}
+
+// describe returns the payload telling the challenged user who offers
+// which game
+func (c *Challenge) describe(challenger *User) map[string]string {
+	payload := map[string]string{
+		"challengeID": c.id,
+		"userUUID":    challenger.uuid,
+		"username":    challenger.name(),
+		"rating":      strconv.Itoa(challenger.currentRating()),
+		"game":        c.variant.rules,
+		"width":       strconv.Itoa(c.variant.width),
+		"height":      strconv.Itoa(c.variant.height),
+		"winLength":   strconv.Itoa(c.variant.winLength),
+		"expiresAt":   c.expiresAt.UTC().Format(time.RFC3339),
+	}
+	c.variant.timeControl.describe(payload)
+	return payload
+}
+
+func (c *Challenge) message(messageType string) Message {
+	return Message{
+		Type:    messageType,
+		Payload: map[string]string{"challengeID": c.id},
+	}
+}
+
+// notice is a message sent once startMutex is released
+type notice struct {
+	user    *User
+	message Message
+}
+
+func deliver(notices []notice) {
+	for _, notice := range notices {
+		notice.user.send(notice.message)
+	}
+}
+
+// onlineUser returns the connected human user the payload names by
+// "userUUID" or "username"
+func onlineUser(repository IRepository, payload map[string]string) *User {
+	if userUUID := payload["userUUID"]; userUUID != "" {
+		user := repository.UserByUUID(userUUID)
+		if user == nil || user.bot || user.isDetached() {
+			return nil
+		}
+		return user
+	}
+	username := payload["username"]
+	if username == "" {
+		return nil
+	}
+	for _, user := range repository.Users() {
+		if !user.bot && !user.isDetached() && strings.EqualFold(user.name(), username) {
+			return user
+		}
+	}
+	return nil
+}
+
+// sendChallenge challenges the user the payload names, it replaces the
+// previous challenge of the user
+func (u *User) sendChallenge(payload map[string]string) (*Challenge, error) {
+	if u.repository.UserByUUID(u.uuid) == nil {
+		return nil, errNotLoggedIn
+	}
+	variant, ok := ParseGameVariant(payload)
+	if !ok {
+		return nil, errInvalidVariant
+	}
+	if u.gameUUID() != "" {
+		return nil, errAlreadyInGame
+	}
+	target := onlineUser(u.repository, payload)
+	if target == nil || target.blocks(u) {
+		return nil, errUserNotFound
+	}
+	if target == u || sameAccount(target, u) {
+		return nil, errOwnChallenge
+	}
+	if target.gameUUID() != "" {
+		return nil, errOpponentAway
+	}
+	var notices []notice
+	startMutex.Lock()
+	for _, challenge := range u.repository.Challenges() {
+		if challenge.challengerUUID == u.uuid {
+			u.repository.RemoveChallenge(challenge)
+			if challenged := u.repository.UserByUUID(challenge.challengedUUID); challenged != nil {
+				notices = append(notices, notice{challenged, challenge.message(ChallengeCancelled)})
+			}
+		}
+	}
+	challenge := NewChallenge(u, target, variant, time.Now())
+	u.repository.AddChallenge(challenge)
+	startMutex.Unlock()
+	deliver(notices)
+	target.send(Message{
+		Type:    ChallengeReceived,
+		Payload: challenge.describe(u),
+	})
+	return challenge, nil
+}
+
+// userChallenge returns the unexpired challenge of the user by id
+func (u *User) userChallenge(id string) *Challenge {
+	challenge := u.repository.ChallengeByID(id)
+	if challenge == nil || challenge.Expired(time.Now()) ||
+		(challenge.challengerUUID != u.uuid && challenge.challengedUUID != u.uuid) {
+		return nil
+	}
+	return challenge
+}
+
+// acceptChallenge starts the game of the challenge, the challenger plays
+// crosses
+func (u *User) acceptChallenge(id string) error {
+	if u.gameUUID() != "" {
+		return errAlreadyInGame
+	}
+	startMutex.Lock()
+	game, err := u.createChallengeGame(id)
+	startMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	game.launch()
+	return nil
+}
+
+// createChallengeGame consumes the challenge, the caller holds startMutex
+func (u *User) createChallengeGame(id string) (*Game, error) {
+	challenge := u.userChallenge(id)
+	if challenge == nil || challenge.challengedUUID != u.uuid {
+		return nil, errChallengeNotFound
+	}
+	challenger := u.repository.UserByUUID(challenge.challengerUUID)
+	if challenger == nil || challenger.gameUUID() != "" {
+		return nil, errOpponentAway
+	}
+	game, err := createGame(u.repository, challenger, u, challenge.variant)
+	if err != nil {
+		return nil, err
+	}
+	u.repository.RemoveChallenge(challenge)
+	return game, nil
+}
+
+// declineChallenge refuses a received challenge or withdraws a sent one
+func (u *User) declineChallenge(id string) error {
+	startMutex.Lock()
+	challenge := u.userChallenge(id)
+	if challenge != nil {
+		u.repository.RemoveChallenge(challenge)
+	}
+	startMutex.Unlock()
+	if challenge == nil {
+		return errChallengeNotFound
+	}
+	messageType := ChallengeDeclined
+	if challenge.challengerUUID == u.uuid {
+		messageType = ChallengeCancelled
+	}
+	if other := u.repository.UserByUUID(challenge.other(u.uuid)); other != nil {
+		other.send(challenge.message(messageType))
+	}
+	return nil
+}
+
+// dropChallenges removes the sent and the received challenges of a leaving
+// user and tells the other users
+func (u *User) dropChallenges() {
+	var notices []notice
+	startMutex.Lock()
+	for _, challenge := range u.repository.Challenges() {
+		if challenge.challengerUUID != u.uuid && challenge.challengedUUID != u.uuid {
+			continue
+		}
+		u.repository.RemoveChallenge(challenge)
+		messageType := ChallengeDeclined
+		if challenge.challengerUUID == u.uuid {
+			messageType = ChallengeCancelled
+		}
+		if other := u.repository.UserByUUID(challenge.other(u.uuid)); other != nil {
+			notices = append(notices, notice{other, challenge.message(messageType)})
+		}
+	}
+	startMutex.Unlock()
+	deliver(notices)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// GameRoom is the room of the game the user plays or watches,
+// every player and spectator is in it
+const GameRoom = "game"
+
+// maxChatRooms bounds the named rooms a user is in at once
+const maxChatRooms = 20
+
+const (
+	// chatHistorySize is the number of recent messages kept in memory per
+	// channel, sqlite keeps chatStoredHistory of them
+	chatHistorySize   = 100
+	chatStoredHistory = 1000
+	// chatHistoryChannels bounds the channels kept in memory, the channel
+	// idle the longest is dropped first
+	chatHistoryChannels = 10000
+	// the default and the maximum ChatHistory page
+	chatHistoryPage    = 20
+	maxChatHistoryPage = 50
+)
+
+// ChatEntry is a chat message kept in the history of its channel: a room,
+// a game or a conversation of two users. seq orders the messages of all
+// channels and is assigned by the repository.
+type ChatEntry struct {
+	seq        int64
+	channel    string
+	senderUUID string
+	// senderAccountID is empty for guests
+	senderAccountID string
+	username        string
+	text            string
+	sentAt          time.Time
+}
+
+func (e *ChatEntry) payload() map[string]string {
+	return map[string]string{
+		"seq":      strconv.FormatInt(e.seq, 10),
+		"text":     html.EscapeString(e.text),
+		"username": html.EscapeString(e.username),
+		"userUUID": e.senderUUID,
+		"sentAt":   e.sentAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+var roomNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+func (u *User) inRoom(room string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.rooms[room]
+}
+
+// joinedRooms returns the names of the rooms the user is in, sorted
+func (u *User) joinedRooms() []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	rooms := make([]string, 0, len(u.rooms))
+	for room := range u.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// roomMembers returns the users in room except the user itself
+func (u *User) roomMembers(room string) []*User {
+	var members []*User
+	for _, user := range u.repository.Users() {
+		if user != u && user.inRoom(room) {
+			members = append(members, user)
+		}
+	}
+	return members
+}
+
+// chatJoin adds the user to the room and tells the members about it
+func (u *User) chatJoin(room string) error {
+	if u.repository.UserByUUID(u.uuid) == nil {
+		return errNotLoggedIn
+	}
+	if room == GameRoom || !roomNamePattern.MatchString(room) {
+		return errInvalidRoom
+	}
+	u.mutex.Lock()
+	if u.rooms[room] {
+		u.mutex.Unlock()
+		return nil
+	}
+	if len(u.rooms) >= maxChatRooms {
+		u.mutex.Unlock()
+		return errTooManyRooms
+	}
+	u.rooms[room] = true
+	u.mutex.Unlock()
+	u.notifyRoom(room, ChatJoined)
+	return nil
+}
+
+// chatLeave removes the user from the room and tells the members about it
+func (u *User) chatLeave(room string) error {
+	u.mutex.Lock()
+	if !u.rooms[room] {
+		u.mutex.Unlock()
+		return errNotInRoom
+	}
+	delete(u.rooms, room)
+	u.mutex.Unlock()
+	u.notifyRoom(room, ChatLeft)
+	return nil
+}
+
+// leaveRooms leaves every room, the user is going away
+func (u *User) leaveRooms() {
+	for _, room := range u.joinedRooms() {
+		u.chatLeave(room)
+	}
+}
+
+func (u *User) notifyRoom(room, messageType string) {
+	message := Message{
+		Type: messageType,
+		Payload: map[string]string{
+			"room":     room,
+			"userUUID": u.uuid,
+			"username": html.EscapeString(u.name()),
+		},
+	}
+	for _, member := range u.roomMembers(room) {
+		member.send(message)
+	}
+}
+
+// chatTarget is where a chat message is addressed: the history channel,
+// the payload fields telling clients about it and the delivery, which is
+// nil when the recipient of a direct message is offline
+type chatTarget struct {
+	channel string
+	fields  map[string]string
+	deliver func(message Message)
+}
+
+// gameChannel is the channel of the game room, it is dropped with the game
+func gameChannel(gameUUID string) string {
+	return "game:" + gameUUID
+}
+
+// chatTarget resolves a user ("to"), a named room ("room") or the game
+// room, which is used when neither is given
+func (u *User) chatTarget(payload map[string]string) (*chatTarget, error) {
+	if to, ok := payload["to"]; ok {
+		if to == u.uuid || to == "" {
+			return nil, errUserNotFound
+		}
+		// both sides of a conversation share the channel
+		first, second := u.uuid, to
+		if second < first {
+			first, second = second, first
+		}
+		target := &chatTarget{
+			"direct:" + first + ":" + second,
+			map[string]string{"to": to},
+			nil,
+		}
+		if recipient := u.repository.UserByUUID(to); recipient != nil {
+			target.deliver = func(message Message) {
+				// the sender isn't told about the block
+				if !recipient.blocks(u) {
+					recipient.send(message)
+				}
+			}
+		}
+		return target, nil
+	}
+
+	room, ok := payload["room"]
+	if !ok || room == GameRoom {
+		gameUUID := u.gameUUID()
+		if gameUUID == "" {
+			gameUUID = u.watching()
+		}
+		game := u.repository.GameByUUID(gameUUID)
+		if game == nil {
+			return nil, errNoActiveGame
+		}
+		return &chatTarget{
+			gameChannel(gameUUID),
+			map[string]string{"room": GameRoom, "gameUUID": gameUUID},
+			func(message Message) {
+				game.chat(u, message)
+			},
+		}, nil
+	}
+
+	if !u.inRoom(room) {
+		return nil, errNotInRoom
+	}
+	return &chatTarget{
+		"room:" + room,
+		map[string]string{"room": room},
+		func(message Message) {
+			for _, member := range u.roomMembers(room) {
+				if !member.blocks(u) {
+					member.send(message)
+				}
+			}
+		},
+	}, nil
+}
+
+// chatSend stores the text of payload in the history of its channel and
+// delivers it to the users who didn't block the sender
+func (u *User) chatSend(payload map[string]string) error {
+	text, ok := payload["text"]
+	if !ok || strings.TrimSpace(text) == "" {
+		return errInvalidPayload
+	}
+	if utf8.RuneCountInString(text) > maxChatMessageLength {
+		return errMessageTooLong
+	}
+	target, err := u.chatTarget(payload)
+	if err != nil {
+		return err
+	}
+	if target.deliver == nil {
+		return errUserNotFound
+	}
+	now := time.Now()
+	if !u.mutedTill(now).IsZero() {
+		return errMuted
+	}
+	if !u.allowChat(now) {
+		return errRateLimited
+	}
+	if chatFilter != nil {
+		text = chatFilter.Mask(text)
+	}
+	entry := &ChatEntry{0, target.channel, u.uuid, u.account(), u.name(), text, now}
+	u.repository.AddChatEntry(entry)
+	message := Message{
+		Type:    MessageNew,
+		Payload: entry.payload(),
+	}
+	for key, value := range target.fields {
+		message.Payload[key] = value
+	}
+	target.deliver(message)
+	return nil
+}
+
+// chatHistory sends a page of the history of the channel addressed by
+// payload, "before" is the cursor returned with the previous page
+func (u *User) chatHistory(request Message) error {
+	target, err := u.chatTarget(request.Payload)
+	if err != nil {
+		return err
+	}
+	var before int64
+	if cursor, ok := request.Payload["before"]; ok {
+		before, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return errInvalidCursor
+		}
+	}
+	limit := chatHistoryPage
+	if raw, ok := request.Payload["limit"]; ok {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxChatHistoryPage {
+			return errInvalidPayload
+		}
+	}
+
+	entries := u.repository.ChatHistory(target.channel, before, limit+1)
+	next := ""
+	if len(entries) > limit {
+		// there is an older page
+		entries = entries[1:]
+		next = strconv.FormatInt(entries[0].seq, 10)
+	}
+	messages := []map[string]string{}
+	for _, entry := range entries {
+		if !u.blocksIdentity(entry.senderUUID, entry.senderAccountID) {
+			messages = append(messages, entry.payload())
+		}
+	}
+	encoded, _ := json.Marshal(messages)
+	payload := map[string]string{
+		"messages":   string(encoded),
+		"nextCursor": next,
+	}
+	for key, value := range target.fields {
+		payload[key] = value
+	}
+	u.send(Message{
+		ID:      request.ID,
+		Type:    ChatHistoryResult,
+		Payload: payload,
+	})
+	return nil
+}
+
+// chat sends message of sender to the players and the spectators who
+// didn't block it
+func (g *Game) chat(sender *User, message Message) {
+	g.do(func() {
+		for _, user := range g.users {
+			if user != sender && !user.blocks(sender) {
+				user.send(message)
+			}
+		}
+		for spectator := range g.spectators {
+			if spectator != sender && !spectator.blocks(sender) {
+				spectator.send(message)
+			}
+		}
+	})
+}
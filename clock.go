@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// Clock is the time source of game clocks, tests replace it to control timeouts
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// maxTimeControl bounds every duration of a time control
+const maxTimeControl = 24 * time.Hour
+
+// TimeControl limits how long players think: total is the time of each
+// player for the whole game, increment is added to it after every move and
+// perMove is the deadline of a single move. Zero values are unlimited.
+type TimeControl struct {
+	total     time.Duration
+	increment time.Duration
+	perMove   time.Duration
+}
+
+// timeControlKeys are the payload keys of a time control, values are seconds
+var timeControlKeys = []string{"time", "increment", "moveTime"}
+
+func (tc *TimeControl) fields() []*time.Duration {
+	return []*time.Duration{&tc.total, &tc.increment, &tc.perMove}
+}
+
+func (tc TimeControl) Enabled() bool {
+	return tc.total > 0 || tc.perMove > 0
+}
+
+func (tc TimeControl) Valid() bool {
+	for _, value := range tc.fields() {
+		if *value < 0 || *value > maxTimeControl {
+			return false
+		}
+	}
+	return tc.increment == 0 || tc.total > 0
+}
+
+// describe adds the time control to a game description when there is one
+func (tc TimeControl) describe(payload map[string]string) {
+	if !tc.Enabled() {
+		return
+	}
+	for i, value := range tc.fields() {
+		payload[timeControlKeys[i]] = strconv.Itoa(int(*value / time.Second))
+	}
+}
+
+// limit returns how long unit may think about the current move
+func (tc TimeControl) limit(remaining time.Duration) time.Duration {
+	if tc.total == 0 || (tc.perMove > 0 && tc.perMove < remaining) {
+		return tc.perMove
+	}
+	return remaining
+}
+
+func durationMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(durationMillis(d), 10)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock fires timers only when the test advances it
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1500000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &fakeTimer{c, c.now.Add(d), f, false}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the time forward and runs the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	var due []func()
+	for _, timer := range c.timers {
+		if !timer.stopped && !timer.at.After(c.now) {
+			timer.stopped = true
+			due = append(due, timer.f)
+		}
+	}
+	c.mutex.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := !t.stopped
+	t.stopped = true
+	return active
+}
+
+func startClockGame(t *testing.T, control TimeControl) (*Game, *fakeClock) {
+	repository := InmemoryRepository()
+	crossUser, zeroUser := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	variant := DefaultGameVariant
+	variant.timeControl = control
+	game := NewGame(repository, crossUser, zeroUser, variant)
+	clock := newFakeClock()
+	game.clock = clock
+	game.startedAt = clock.Now()
+	crossUser.setGameUUID(game.uuid)
+	zeroUser.setGameUUID(game.uuid)
+	repository.AddGame(game)
+	game.Start()
+	for _, user := range game.users {
+		<-user.writeChan
+		<-user.writeChan
+	}
+	return game, clock
+}
+
+func TestGame_TimeIncrement(t *testing.T) {
+	game, clock := startClockGame(t, TimeControl{10 * time.Second, 2 * time.Second, 0})
+
+	clock.Advance(3 * time.Second)
+	err := game.Move(game.crossUser, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range game.users {
+		if gotMsg := <-user.writeChan; gotMsg.Type != GameMoved || !reflect.DeepEqual(gotMsg.Payload, game.GetField()) {
+			t.Errorf("invalid write message %v", gotMsg)
+		}
+		expectMsg := Message{
+			Type: GameClock,
+			Payload: map[string]string{
+				"crossTimeLeft": "9000",
+				"zeroTimeLeft":  "10000",
+				"moveTimeLeft":  "10000",
+			},
+		}
+		if gotMsg := <-user.writeChan; !reflect.DeepEqual(expectMsg, gotMsg) {
+			t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+		}
+	}
+	var gotMsg Message
+
+	clock.Advance(10 * time.Second)
+	expectMsg := Message{
+		Type: GameWinner,
+		Payload: map[string]string{
+			"winner": "CROSS",
+			"reason": "timeout",
+		},
+	}
+	for _, user := range game.users {
+		gotMsg = <-user.writeChan
+		if !reflect.DeepEqual(expectMsg, gotMsg) {
+			t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+		}
+	}
+	if replay := game.repository.ReplayByUUID(game.uuid); replay == nil || replay.result != resultCrossWins {
+		t.Errorf("replay = %v, want result %v", replay, resultCrossWins)
+	}
+	if err := game.Move(game.zeroUser, 1); err != errGameIsOver {
+		t.Errorf("move after timeout = %v, want %v", err, errGameIsOver)
+	}
+}
+
+func TestGame_MoveDeadline(t *testing.T) {
+	game, clock := startClockGame(t, TimeControl{0, 0, 5 * time.Second})
+
+	clock.Advance(4 * time.Second)
+	err := game.Move(game.crossUser, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-game.crossUser.writeChan
+	<-game.zeroUser.writeChan
+	gotMsg := <-game.crossUser.writeChan
+	<-game.zeroUser.writeChan
+	if _, ok := gotMsg.Payload["crossTimeLeft"]; gotMsg.Type != GameClock || ok || gotMsg.Payload["moveTimeLeft"] != "5000" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+
+	// the deadline doesn't accumulate
+	clock.Advance(5 * time.Second)
+	for _, user := range game.users {
+		gotMsg = <-user.writeChan
+		if gotMsg.Type != GameWinner || gotMsg.Payload["winner"] != "CROSS" {
+			t.Errorf("invalid write message %v", gotMsg)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+// repository backends
+const (
+	RepositoryMemory = "memory"
+	RepositorySqlite = "sqlite"
+)
+
+// Config holds the settings of the server. Every setting is read from the
+// YAML file, the environment and the command line, a later source wins.
+type Config struct {
+	Listen        string        `yaml:"listen"`
+	MatchInterval time.Duration `yaml:"matchInterval"`
+	CleanInterval time.Duration `yaml:"cleanInterval"`
+	// WriteBuffer is the capacity of the queue of messages to a user
+	WriteBuffer          int           `yaml:"writeBuffer"`
+	WriteTimeout         time.Duration `yaml:"writeTimeout"`
+	ReconnectGracePeriod time.Duration `yaml:"reconnectGracePeriod"`
+	BotWait              time.Duration `yaml:"botWait"`
+	BotDifficulty        string        `yaml:"botDifficulty"`
+	InviteTTL            time.Duration `yaml:"inviteTTL"`
+	ChallengeTTL         time.Duration `yaml:"challengeTTL"`
+	ChatRateLimit        int           `yaml:"chatRateLimit"`
+	ChatRateWindow       time.Duration `yaml:"chatRateWindow"`
+	ChatWordList         string        `yaml:"chatWordList"`
+	AdminKey             string        `yaml:"adminKey"`
+	AuthSecret           string        `yaml:"authSecret"`
+	Repository           string        `yaml:"repository"`
+	SqlitePath           string        `yaml:"sqlitePath"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		":17666",
+		15 * time.Second,
+		15 * time.Second,
+		writeBufferSize,
+		writeTimeout,
+		reconnectGracePeriod,
+		botWait,
+		botDifficulty,
+		inviteTTL,
+		challengeTTL,
+		chatRateLimit,
+		chatRateWindow,
+		"",
+		"",
+		"",
+		RepositoryMemory,
+		"mobile-backend.db",
+	}
+}
+
+// configOption binds a setting to its flag and environment variable
+type configOption struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *Config) interface{}
+}
+
+var configOptions = []configOption{
+	{"listen", "LISTEN", "address to serve on", func(c *Config) interface{} { return &c.Listen }},
+	{"match-interval", "MATCH_INTERVAL", "how often searching players are matched", func(c *Config) interface{} { return &c.MatchInterval }},
+	{"clean-interval", "CLEAN_INTERVAL", "how often finished games and expired invites are removed", func(c *Config) interface{} { return &c.CleanInterval }},
+	{"write-buffer", "WRITE_BUFFER", "messages queued for a user", func(c *Config) interface{} { return &c.WriteBuffer }},
+	{"write-timeout", "WRITE_TIMEOUT", "how long a client may fall behind before it is disconnected", func(c *Config) interface{} { return &c.WriteTimeout }},
+	{"reconnect-grace-period", "RECONNECT_GRACE_PERIOD", "how long a player survives without a socket", func(c *Config) interface{} { return &c.ReconnectGracePeriod }},
+	{"bot-wait", "BOT_WAIT", "search time before a bot is matched, 0 disables bots", func(c *Config) interface{} { return &c.BotWait }},
+	{"bot-difficulty", "BOT_DIFFICULTY", "easy, medium or perfect", func(c *Config) interface{} { return &c.BotDifficulty }},
+	{"invite-ttl", "INVITE_TTL", "lifetime of private game invites", func(c *Config) interface{} { return &c.InviteTTL }},
+	{"challenge-ttl", "CHALLENGE_TTL", "lifetime of challenges", func(c *Config) interface{} { return &c.ChallengeTTL }},
+	{"chat-rate-limit", "CHAT_RATE_LIMIT", "chat messages a user sends per chat-rate-window", func(c *Config) interface{} { return &c.ChatRateLimit }},
+	{"chat-rate-window", "CHAT_RATE_WINDOW", "window of chat-rate-limit", func(c *Config) interface{} { return &c.ChatRateWindow }},
+	{"chat-word-list", "CHAT_WORD_LIST", "file of words masked in chat", func(c *Config) interface{} { return &c.ChatWordList }},
+	{"admin-key", "ADMIN_KEY", "key of moderation requests, empty disables them", func(c *Config) interface{} { return &c.AdminKey }},
+	{"auth-secret", "AUTH_SECRET", "HMAC secret of auth tokens", func(c *Config) interface{} { return &c.AuthSecret }},
+	{"repository", "REPOSITORY", "memory or sqlite", func(c *Config) interface{} { return &c.Repository }},
+	{"sqlite-path", "SQLITE_PATH", "database file of the sqlite repository", func(c *Config) interface{} { return &c.SqlitePath }},
+}
+
+// setConfigField parses raw into the setting field points to
+func setConfigField(field interface{}, raw string) error {
+	var err error
+	switch field := field.(type) {
+	case *string:
+		*field = raw
+	case *int:
+		*field, err = strconv.Atoi(raw)
+	case *time.Duration:
+		*field, err = time.ParseDuration(raw)
+	}
+	return err
+}
+
+// configValue is the flag.Value of a setting
+type configValue struct {
+	field interface{}
+}
+
+func (v configValue) String() string {
+	switch field := v.field.(type) {
+	case *string:
+		return *field
+	case *int:
+		return strconv.Itoa(*field)
+	case *time.Duration:
+		return field.String()
+	}
+	return ""
+}
+
+func (v configValue) Set(raw string) error {
+	return setConfigField(v.field, raw)
+}
+
+// LoadConfig reads the file named by the -config flag or the CONFIG_FILE
+// variable, then the environment and then args, and validates the result
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	flags := DefaultConfig()
+	flagSet := flag.NewFlagSet("mobile-backend", flag.ContinueOnError)
+	path := flagSet.String("config", getenv("CONFIG_FILE"), "YAML configuration file")
+	options := map[string]configOption{}
+	for _, option := range configOptions {
+		options[option.flag] = option
+		flagSet.Var(configValue{option.field(flags)}, option.flag, option.usage)
+	}
+	err := flagSet.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	if *path != "" {
+		data, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.UnmarshalStrict(data, config)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", *path, err)
+		}
+	}
+	for _, option := range configOptions {
+		if raw := getenv(option.env); raw != "" {
+			err = setConfigField(option.field(config), raw)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", option.env, err)
+			}
+		}
+	}
+	flagSet.Visit(func(f *flag.Flag) {
+		if option, ok := options[f.Name]; ok {
+			setConfigField(option.field(config), f.Value.String())
+		}
+	})
+	return config, config.Validate()
+}
+
+// Validate reports the first invalid setting
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	for name, value := range map[string]time.Duration{
+		"matchInterval":        c.MatchInterval,
+		"cleanInterval":        c.CleanInterval,
+		"writeTimeout":         c.WriteTimeout,
+		"reconnectGracePeriod": c.ReconnectGracePeriod,
+		"inviteTTL":            c.InviteTTL,
+		"challengeTTL":         c.ChallengeTTL,
+		"chatRateWindow":       c.ChatRateWindow,
+	} {
+		if value <= 0 {
+			return fmt.Errorf("%v: must be positive, got %v", name, value)
+		}
+	}
+	if c.BotWait < 0 {
+		return fmt.Errorf("botWait: must not be negative, got %v", c.BotWait)
+	}
+	if c.WriteBuffer < 1 {
+		return fmt.Errorf("writeBuffer: must be positive, got %v", c.WriteBuffer)
+	}
+	if c.ChatRateLimit < 1 {
+		return fmt.Errorf("chatRateLimit: must be positive, got %v", c.ChatRateLimit)
+	}
+	if _, ok := botDepths[c.BotDifficulty]; !ok {
+		return fmt.Errorf("botDifficulty: unknown difficulty %q", c.BotDifficulty)
+	}
+	switch c.Repository {
+	case RepositoryMemory:
+	case RepositorySqlite:
+		if c.SqlitePath == "" {
+			return errors.New("sqlitePath: required by the sqlite repository")
+		}
+	default:
+		return fmt.Errorf("repository: unknown backend %q", c.Repository)
+	}
+	return nil
+}
+
+// Apply sets the package settings and opens the repository of the config
+func (c *Config) Apply() (IRepository, error) {
+	writeBufferSize = c.WriteBuffer
+	writeTimeout = c.WriteTimeout
+	reconnectGracePeriod = c.ReconnectGracePeriod
+	botWait = c.BotWait
+	botDifficulty = c.BotDifficulty
+	inviteTTL = c.InviteTTL
+	challengeTTL = c.ChallengeTTL
+	chatRateLimit = c.ChatRateLimit
+	chatRateWindow = c.ChatRateWindow
+	adminKey = c.AdminKey
+	authSecret = []byte(c.AuthSecret)
+	if c.ChatWordList != "" {
+		filter, err := LoadWordFilter(c.ChatWordList)
+		if err != nil {
+			return nil, fmt.Errorf("chatWordList: %v", err)
+		}
+		chatFilter = filter
+	}
+	if c.Repository == RepositorySqlite {
+		return SqliteRepository(c.SqlitePath)
+	}
+	return InmemoryRepository(), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("listen: \":8000\"\nmatchInterval: 5s\nwriteBuffer: 8\nrepository: sqlite\nsqlitePath: file.db\n"), 0600)
+
+	env := map[string]string{
+		"CONFIG_FILE":    path,
+		"MATCH_INTERVAL": "3s",
+		"WRITE_BUFFER":   "16",
+	}
+	config, err := LoadConfig([]string{"-write-buffer", "32", "-bot-difficulty", BotPerfect}, func(key string) string {
+		return env[key]
+	})
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	want := DefaultConfig()
+	want.Listen = ":8000"
+	want.MatchInterval = 3 * time.Second
+	want.WriteBuffer = 32
+	want.BotDifficulty = BotPerfect
+	want.Repository = RepositorySqlite
+	want.SqlitePath = "file.db"
+	if *config != *want {
+		t.Errorf("LoadConfig() = %+v, want %+v", *config, *want)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("listenAddress: \":8000\"\n"), 0600)
+
+	tests := []struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{[]string{"-config", path}, nil, "listenAddress"},
+		{[]string{"-listen", "17666"}, nil, "listen"},
+		{nil, map[string]string{"CLEAN_INTERVAL": "0s"}, "cleanInterval"},
+		{nil, map[string]string{"BOT_WAIT": "soon"}, "BOT_WAIT"},
+		{[]string{"-write-buffer", "0"}, nil, "writeBuffer"},
+		{[]string{"-repository", "redis"}, nil, "repository"},
+		{[]string{"-repository", "sqlite", "-sqlite-path", ""}, nil, "sqlitePath"},
+		{[]string{"-bot-difficulty", "impossible"}, nil, "botDifficulty"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfig(tt.args, func(key string) string {
+			return tt.env[key]
+		})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadConfig(%v, %v) error = %v, want a %v error", tt.args, tt.env, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfig_Help(t *testing.T) {
+	if os.Getenv("CONFIG_TEST_HELP") != "" {
+		os.Args = []string{"mobile-backend", "-h"}
+		main()
+		return
+	}
+	if _, err := LoadConfig([]string{"-h"}, os.Getenv); err != flag.ErrHelp {
+		t.Errorf("LoadConfig(-h) error = %v, want %v", err, flag.ErrHelp)
+	}
+
+	// main exits, run it in a child process
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoadConfig_Help$")
+	cmd.Env = append(os.Environ(), "CONFIG_TEST_HELP=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("mobile-backend -h error = %v, want exit status 0", err)
+	}
+	if !strings.Contains(string(output), "-listen") {
+		t.Errorf("mobile-backend -h printed %q, want the usage", output)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+// ConnectFourRules drops units into columns: the move position is a column
+// number starting from 1 and the unit falls to the lowest empty cell.
+type ConnectFourRules struct {
+	board
+}
+
+// landing returns the cell a unit dropped into column ends up in,
+// 0 when the column is full.
+func (r *ConnectFourRules) landing(field GameField, column int) int {
+	for y := r.height - 1; y >= 0; y-- {
+		position := r.position(column-1, y)
+		if field[position] == EMPTY {
+			return position
+		}
+	}
+	return 0
+}
+
+func (r *ConnectFourRules) ValidateMove(field GameField, unit GameUnit, position int) error {
+	if position < 1 || position > r.width {
+		return errInvalidPosition
+	}
+	if r.landing(field, position) == 0 {
+		return errColumnFull
+	}
+	return nil
+}
+
+func (r *ConnectFourRules) ApplyMove(field GameField, unit GameUnit, position int) int {
+	cell := r.landing(field, position)
+	field[cell] = unit
+	return cell
+}
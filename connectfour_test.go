@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestConnectFourRules(t *testing.T) {
+	variant, ok := ParseGameVariant(map[string]string{"game": ConnectFour})
+	if !ok {
+		t.Fatalf("connect four variant should be valid")
+	}
+	rules := variant.Rules()
+	field := rules.NewField()
+
+	// CROSS builds a column in the first column, ZERO plays next to it
+	moves := []struct {
+		unit   GameUnit
+		column int
+		cell   int
+	}{
+		{CROSS, 1, 36},
+		{ZERO, 2, 37},
+		{CROSS, 1, 29},
+		{ZERO, 2, 30},
+		{CROSS, 1, 22},
+		{ZERO, 2, 23},
+	}
+	for _, move := range moves {
+		if err := rules.ValidateMove(field, move.unit, move.column); err != nil {
+			t.Fatalf("ValidateMove(%v) = %v", move.column, err)
+		}
+		if cell := rules.ApplyMove(field, move.unit, move.column); cell != move.cell {
+			t.Errorf("ApplyMove(%v) = %v, want %v", move.column, cell, move.cell)
+		}
+	}
+	if _, over := rules.Outcome(field); over {
+		t.Fatalf("game shouldn't be over yet")
+	}
+
+	rules.ApplyMove(field, CROSS, 1)
+	if winner, over := rules.Outcome(field); !over || winner != CROSS {
+		t.Errorf("Outcome() = %v, %v, want %v, %v", winner, over, CROSS, true)
+	}
+
+	rules.ApplyMove(field, ZERO, 1)
+	rules.ApplyMove(field, CROSS, 1)
+	if err := rules.ValidateMove(field, CROSS, 1); err != errColumnFull {
+		t.Errorf("ValidateMove() on full column = %v, want %v", err, errColumnFull)
+	}
+	if err := rules.ValidateMove(field, CROSS, 8); err != errInvalidPosition {
+		t.Errorf("ValidateMove() outside of the board = %v, want %v", err, errInvalidPosition)
+	}
+}
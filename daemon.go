@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -13,8 +14,25 @@ func gameCleaner(repository IRepository, context context.Context, ticker <-chan
 			return
 		case <-ticker:
 			for _, game := range repository.GameSessions() {
-				if game.isOver {
+				if game.IsOver() {
 					repository.RemoveGame(game)
+					game.Stop()
+				}
+			}
+			now := time.Now()
+			for _, invite := range repository.Invites() {
+				if invite.Expired(now) {
+					repository.RemoveInvite(invite)
+				}
+			}
+			for _, challenge := range repository.Challenges() {
+				if challenge.Expired(now) {
+					repository.RemoveChallenge(challenge)
+					for _, userUUID := range []string{challenge.challengerUUID, challenge.challengedUUID} {
+						if user := repository.UserByUUID(userUUID); user != nil {
+							user.send(challenge.message(ChallengeExpired))
+						}
+					}
 				}
 			}
 		}
@@ -22,52 +40,99 @@ func gameCleaner(repository IRepository, context context.Context, ticker <-chan
 }
 
 func gameSessionsCreator(repository IRepository, context context.Context, ticker <-chan time.Time) {
-	var playerFirst *User
-	var playerSecond *User
 	for {
 		select {
 		case <-context.Done():
 			return
 		case <-ticker:
-			playerFirst = nil
-			playerSecond = nil
-			for _, user := range repository.UsersInSearchInsertionOrder() {
-				if playerFirst == nil {
-					playerFirst = user
-				} else {
-					playerSecond = user
-					log.Println("Creating the game...")
-					game := &Game{
-						generateUUID(),
-						[]*User{playerFirst, playerSecond},
-						playerFirst,
-						playerSecond,
-						CROSS,
-						false,
-						GameField{
-							1: EMPTY,
-							2: EMPTY,
-							3: EMPTY,
-							4: EMPTY,
-							5: EMPTY,
-							6: EMPTY,
-							7: EMPTY,
-							8: EMPTY,
-							9: EMPTY,
-						},
+			now := time.Now()
+			users := repository.UsersInSearchInsertionOrder()
+			matched := map[*User]bool{}
+			for i, playerFirst := range users {
+				if matched[playerFirst] {
+					continue
+				}
+				for _, playerSecond := range users[i+1:] {
+					variant, _ := playerFirst.search()
+					if secondVariant, _ := playerSecond.search(); matched[playerSecond] || secondVariant != variant {
+						continue
 					}
-					go game.Start()
-					playerFirst.currentGameUUID = game.uuid
-					playerSecond.currentGameUUID = game.uuid
-					repository.AddGame(game)
-
-					repository.RemoveUserInSearch(playerFirst)
-					repository.RemoveUserInSearch(playerSecond)
-
-					playerFirst = nil
-					playerSecond = nil
+					if sameAccount(playerFirst, playerSecond) || !ratingsMatch(playerFirst, playerSecond, now) {
+						continue
+					}
+					if startGame(repository, playerFirst, playerSecond, variant) != nil {
+						continue
+					}
+					matched[playerFirst] = true
+					matched[playerSecond] = true
+					break
+				}
+			}
+			if botWait <= 0 {
+				continue
+			}
+			for _, player := range users {
+				variant, startedAt := player.search()
+				if matched[player] || now.Sub(startedAt) < botWait {
+					continue
+				}
+				bot := NewBot(repository, variant, botDifficulty)
+				if startGame(repository, player, bot.user, variant) == nil {
+					go bot.run()
 				}
 			}
 		}
 	}
 }
+
+// startMutex serializes starting games, so that a player joins a single
+// game and an invite or a challenge starts a single one. It guards the
+// repository only: nothing waits for a client or a game while holding it.
+var startMutex sync.Mutex
+
+// startGame starts the game of the players unless one of them plays
+// another game already or both are sessions of an account
+func startGame(repository IRepository, crossUser, zeroUser *User, variant GameVariant) error {
+	startMutex.Lock()
+	game, err := createGame(repository, crossUser, zeroUser, variant)
+	startMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	game.launch()
+	return nil
+}
+
+// createGame is startGame for callers holding startMutex, they launch the
+// game once they released it
+func createGame(repository IRepository, crossUser, zeroUser *User, variant GameVariant) (*Game, error) {
+	if crossUser.gameUUID() != "" || zeroUser.gameUUID() != "" {
+		return nil, errAlreadyInGame
+	}
+	if sameAccount(crossUser, zeroUser) {
+		return nil, errSameAccount
+	}
+	log.Println("Creating the game...")
+	game := NewGame(repository, crossUser, zeroUser, variant)
+	crossUser.setGameUUID(game.uuid)
+	zeroUser.setGameUUID(game.uuid)
+	repository.SaveUser(crossUser)
+	repository.SaveUser(zeroUser)
+	repository.AddGame(game)
+
+	repository.RemoveUserInSearch(crossUser)
+	repository.RemoveUserInSearch(zeroUser)
+	return game, nil
+}
+
+// launch tells the players about the game created by createGame
+func (g *Game) launch() {
+	for _, user := range g.users {
+		// players don't watch other games
+		user.unwatch()
+	}
+	go g.Start()
+	for _, user := range g.users {
+		user.announcePresence()
+	}
+}
@@ -30,6 +30,31 @@ func Test_gameCleaner(t *testing.T) {
 	}
 }
 
+func Test_gameCleaner_BusyGame(t *testing.T) {
+	// nobody reads the messages of the stuck game, it waits for writeTimeout
+	stuckGame := MockGame(MockUser(), MockUser())
+	go stuckGame.Start()
+	overGame := MockGame(MockUser(), MockUser())
+	overGame.isOver = true
+
+	repository := InmemoryRepository()
+	repository.AddGame(stuckGame)
+	repository.AddGame(overGame)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameCleaner(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	time.Sleep(2 * gameQueryTimeout)
+	cancel()
+
+	if !reflect.DeepEqual(map[string]*Game{stuckGame.uuid: stuckGame}, repository.GameSessions()) {
+		t.Errorf("Error while running cleaner: a busy game held up the cleaner")
+	}
+	if games := liveGames(repository); games != "[]" {
+		t.Errorf("liveGames() = %v, want []", games)
+	}
+}
+
 func Test_gameSessionsCreator(t *testing.T) {
 	mockUserFirst := MockUser()
 	mockUserSecond := MockUser()
@@ -50,3 +75,194 @@ func Test_gameSessionsCreator(t *testing.T) {
 		t.Errorf("Game count should be 1, got %v", len(repository.GameSessions()))
 	}
 }
+
+func Test_gameSessionsCreator_InGame(t *testing.T) {
+	repository := InmemoryRepository()
+	playing, searching := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	playing.currentGameUUID = generateUUID()
+	repository.AddUser(playing)
+	repository.AddUser(searching)
+
+	playing.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	if gotMsg := <-playing.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "ALREADY_IN_GAME" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	// joined the search before the game started
+	playing.searchStartedAt = time.Now()
+	searching.searchStartedAt = time.Now()
+	repository.AddUserInSearch(playing)
+	repository.AddUserInSearch(searching)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	if len(repository.GameSessions()) != 0 || playing.gameUUID() == "" {
+		t.Errorf("a player in a game was matched again")
+	}
+	if err := startGame(repository, searching, playing, DefaultGameVariant); err != errAlreadyInGame {
+		t.Errorf("startGame() error = %v, want %v", err, errAlreadyInGame)
+	}
+}
+
+func Test_startGame_SlowClient(t *testing.T) {
+	repository := InmemoryRepository()
+	challenger, slow := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	first, second := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	for _, user := range []*User{challenger, slow, first, second} {
+		repository.AddUser(user)
+	}
+	// the queue of the slow user is full, the challenge waits for room
+	for i := 0; i < cap(slow.writeChan); i++ {
+		slow.writeChan <- Message{Type: GameSpectators}
+	}
+	go challenger.sendChallenge(map[string]string{"userUUID": slow.uuid})
+	time.Sleep(50 * time.Millisecond)
+
+	started := make(chan error)
+	go func() {
+		started <- startGame(repository, first, second, DefaultGameVariant)
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("startGame() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("a slow client held up starting games")
+	}
+}
+
+func Test_gameSessionsCreator_SameAccount(t *testing.T) {
+	repository := InmemoryRepository()
+	first, second := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	for _, user := range []*User{first, second} {
+		user.accountID = "account"
+		user.searchStartedAt = time.Now()
+		repository.AddUser(user)
+		repository.AddUserInSearch(user)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	if len(repository.GameSessions()) != 0 {
+		t.Errorf("sessions of an account were matched")
+	}
+	if err := startGame(repository, first, second, DefaultGameVariant); err != errSameAccount {
+		t.Errorf("startGame() error = %v, want %v", err, errSameAccount)
+	}
+	if _, err := first.sendChallenge(map[string]string{"userUUID": second.uuid}); err != errOwnChallenge {
+		t.Errorf("sendChallenge() error = %v, want %v", err, errOwnChallenge)
+	}
+	invite, _ := first.createPrivate(DefaultGameVariant)
+	if err := second.joinPrivate(invite.code); err != errOwnInvite {
+		t.Errorf("joinPrivate() error = %v, want %v", err, errOwnInvite)
+	}
+}
+
+func Test_gameSessionsCreator_Variants(t *testing.T) {
+	mockUserFirst := MockUser()
+	mockUserSecond := MockUser()
+	mockUserThird := MockUser()
+	mockUserSecond.searchVariant = GameVariant{TicTacToe, 15, 15, 5, TimeControl{}}
+	// too early for a bot
+	mockUserSecond.searchStartedAt = time.Now()
+
+	repository := InmemoryRepository()
+	for _, user := range []*User{mockUserFirst, mockUserSecond, mockUserThird} {
+		repository.AddUser(user)
+		repository.AddUserInSearch(user)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	if len(repository.GameSessions()) != 1 {
+		t.Fatalf("Game count should be 1, got %v", len(repository.GameSessions()))
+	}
+	for _, game := range repository.GameSessions() {
+		if game.crossUser != mockUserFirst || game.zeroUser != mockUserThird {
+			t.Errorf("players with different variants were matched")
+		}
+	}
+	if len(repository.UsersInSearch()) != 1 {
+		t.Errorf("user with another variant should still be in search")
+	}
+}
+
+func Test_gameSessionsCreator_Bot(t *testing.T) {
+	mockUser := MockUser()
+	mockUser.searchStartedAt = time.Now().Add(-botWait)
+
+	repository := InmemoryRepository()
+	repository.AddUser(mockUser)
+	repository.AddUserInSearch(mockUser)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	if len(repository.GameSessions()) != 1 {
+		t.Fatalf("Game count should be 1, got %v", len(repository.GameSessions()))
+	}
+	for _, game := range repository.GameSessions() {
+		if game.crossUser != mockUser || !game.zeroUser.bot {
+			t.Errorf("player wasn't matched with a bot")
+		}
+	}
+}
+
+func Test_gameCleaner_Invites(t *testing.T) {
+	mockUser := MockUser()
+	expired := NewInvite(mockUser, DefaultGameVariant, time.Now().Add(-inviteTTL))
+	active := NewInvite(mockUser, DefaultGameVariant, time.Now())
+
+	repository := InmemoryRepository()
+	repository.AddInvite(expired)
+	repository.AddInvite(active)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameCleaner(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	if !reflect.DeepEqual(map[string]*Invite{active.code: active}, repository.Invites()) {
+		t.Errorf("Error while running cleaner: expired invite wasn't removed")
+	}
+}
+
+func Test_gameCleaner_Challenges(t *testing.T) {
+	repository := InmemoryRepository()
+	challenger, challenged := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	repository.AddUser(challenger)
+	expired := NewChallenge(challenger, challenged, DefaultGameVariant, time.Now().Add(-challengeTTL))
+	active := NewChallenge(challenger, challenged, DefaultGameVariant, time.Now())
+	repository.AddChallenge(expired)
+	repository.AddChallenge(active)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameCleaner(repository, ctx, time.Tick(1*time.Nanosecond))
+
+	gotMsg := <-challenger.writeChan
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	if gotMsg.Type != ChallengeExpired || gotMsg.Payload["challengeID"] != expired.id {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	if !reflect.DeepEqual(map[string]*Challenge{active.id: active}, repository.Challenges()) {
+		t.Errorf("Error while running cleaner: expired challenge wasn't removed")
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFriends bounds the friends and the pending requests of an account
+const maxFriends = 200
+
+// presence statuses pushed to friends
+const (
+	PresenceOnline    = "online"
+	PresenceSearching = "searching"
+	PresenceInGame    = "in_game"
+	PresenceOffline   = "offline"
+)
+
+// Friendship is a friend request of requesterID to addresseeID, accepted
+// ones make the accounts friends
+type Friendship struct {
+	requesterID string
+	addresseeID string
+	accepted    bool
+	createdAt   time.Time
+}
+
+// other returns the account of the friendship which isn't accountID
+func (f *Friendship) other(accountID string) string {
+	if f.requesterID == accountID {
+		return f.addresseeID
+	}
+	return f.requesterID
+}
+
+// friendsMutex makes answering a request atomic with sending one back
+var friendsMutex = &sync.Mutex{}
+
+func friendship(repository IRepository, accountID, otherID string) *Friendship {
+	for _, friendship := range repository.Friendships(accountID) {
+		if friendship.other(accountID) == otherID {
+			return friendship
+		}
+	}
+	return nil
+}
+
+// sessions returns the connected users of the account
+func sessions(repository IRepository, accountID string) []*User {
+	var users []*User
+	for _, user := range repository.Users() {
+		if user.account() == accountID && !user.isDetached() {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// presenceOf returns the busiest status among the sessions of the account
+func presenceOf(repository IRepository, accountID string) string {
+	status := PresenceOffline
+	inSearch := repository.UsersInSearch()
+	for _, user := range sessions(repository, accountID) {
+		if user.gameUUID() != "" {
+			return PresenceInGame
+		}
+		if _, ok := inSearch[user.uuid]; ok {
+			status = PresenceSearching
+		} else if status == PresenceOffline {
+			status = PresenceOnline
+		}
+	}
+	return status
+}
+
+func presenceMessage(repository IRepository, account *Account) Message {
+	return Message{
+		Type: Presence,
+		Payload: map[string]string{
+			"accountID": account.id,
+			"username":  account.username,
+			"status":    presenceOf(repository, account.id),
+		},
+	}
+}
+
+// notifyAccount sends message to every session of the account
+func notifyAccount(repository IRepository, accountID string, message Message) {
+	for _, user := range sessions(repository, accountID) {
+		user.send(message)
+	}
+}
+
+// announcePresence tells the friends of the user's account what it's doing
+func (u *User) announcePresence() {
+	accountID := u.account()
+	if accountID == "" {
+		// guests have no friends
+		return
+	}
+	account := u.repository.AccountByID(accountID)
+	if account == nil {
+		return
+	}
+	message := presenceMessage(u.repository, account)
+	for _, friendship := range u.repository.Friendships(account.id) {
+		if friendship.accepted {
+			notifyAccount(u.repository, friendship.other(account.id), message)
+		}
+	}
+}
+
+// friendTarget returns the own account and the account the payload names
+// by "accountID" or "username"
+func (u *User) friendTarget(payload map[string]string) (*Account, *Account, error) {
+	own := u.repository.AccountByID(u.account())
+	if own == nil {
+		return nil, nil, errAccountRequired
+	}
+	var target *Account
+	if accountID := payload["accountID"]; accountID != "" {
+		target = u.repository.AccountByID(accountID)
+	} else if username := payload["username"]; username != "" {
+		target = u.repository.AccountByUsername(username)
+	}
+	if target == nil || target.id == own.id {
+		return nil, nil, errAccountNotFound
+	}
+	return own, target, nil
+}
+
+// friendRequest asks the target to become friends, a request the target
+// sent before is accepted instead
+func (u *User) friendRequest(payload map[string]string) error {
+	own, target, err := u.friendTarget(payload)
+	if err != nil {
+		return err
+	}
+	friendsMutex.Lock()
+	defer friendsMutex.Unlock()
+	existing := friendship(u.repository, own.id, target.id)
+	switch {
+	case existing == nil:
+	case existing.accepted:
+		return errAlreadyFriends
+	case existing.requesterID == own.id:
+		return nil
+	default:
+		u.acceptFriendship(existing, own, target)
+		return nil
+	}
+	if len(u.repository.Friendships(own.id)) >= maxFriends || len(u.repository.Friendships(target.id)) >= maxFriends {
+		return errTooManyFriends
+	}
+	u.repository.SaveFriendship(&Friendship{own.id, target.id, false, time.Now()})
+	notifyAccount(u.repository, target.id, Message{
+		Type:    FriendRequested,
+		Payload: map[string]string{"accountID": own.id, "username": own.username},
+	})
+	return nil
+}
+
+// acceptFriendship accepts the request of requester and makes both
+// accounts see each other's presence
+func (u *User) acceptFriendship(request *Friendship, own, requester *Account) {
+	accepted := *request
+	accepted.accepted = true
+	u.repository.SaveFriendship(&accepted)
+	notifyAccount(u.repository, requester.id, Message{
+		Type:    FriendAccepted,
+		Payload: map[string]string{"accountID": own.id, "username": own.username},
+	})
+	notifyAccount(u.repository, requester.id, presenceMessage(u.repository, own))
+	notifyAccount(u.repository, own.id, presenceMessage(u.repository, requester))
+}
+
+// friendAnswer accepts or declines the request of the target
+func (u *User) friendAnswer(payload map[string]string, accept bool) error {
+	own, target, err := u.friendTarget(payload)
+	if err != nil {
+		return err
+	}
+	friendsMutex.Lock()
+	defer friendsMutex.Unlock()
+	request := friendship(u.repository, own.id, target.id)
+	if request == nil || request.accepted || request.requesterID != target.id {
+		return errNoFriendRequest
+	}
+	if accept {
+		u.acceptFriendship(request, own, target)
+	} else {
+		u.repository.RemoveFriendship(request)
+	}
+	return nil
+}
+
+// friendRemove ends a friendship or withdraws a request
+func (u *User) friendRemove(payload map[string]string) error {
+	own, target, err := u.friendTarget(payload)
+	if err != nil {
+		return err
+	}
+	friendsMutex.Lock()
+	defer friendsMutex.Unlock()
+	existing := friendship(u.repository, own.id, target.id)
+	if existing == nil {
+		return errNotFriends
+	}
+	u.repository.RemoveFriendship(existing)
+	if existing.accepted {
+		notifyAccount(u.repository, target.id, Message{
+			Type:    FriendRemoved,
+			Payload: map[string]string{"accountID": own.id},
+		})
+	}
+	return nil
+}
+
+// friendList answers FriendList with the friends and their presence and
+// the pending requests, JSON lists sorted by username
+func (u *User) friendList(request Message) error {
+	own := u.repository.AccountByID(u.account())
+	if own == nil {
+		return errAccountRequired
+	}
+	lists := map[string][]map[string]string{
+		"friends":  {},
+		"incoming": {},
+		"outgoing": {},
+	}
+	for _, friendship := range u.repository.Friendships(own.id) {
+		other := u.repository.AccountByID(friendship.other(own.id))
+		if other == nil {
+			continue
+		}
+		entry := map[string]string{"accountID": other.id, "username": other.username}
+		switch {
+		case friendship.accepted:
+			entry["status"] = presenceOf(u.repository, other.id)
+			lists["friends"] = append(lists["friends"], entry)
+		case friendship.requesterID == own.id:
+			lists["outgoing"] = append(lists["outgoing"], entry)
+		default:
+			lists["incoming"] = append(lists["incoming"], entry)
+		}
+	}
+	payload := map[string]string{}
+	for name, list := range lists {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i]["username"] < list[j]["username"]
+		})
+		encoded, _ := json.Marshal(list)
+		payload[name] = string(encoded)
+	}
+	u.send(Message{
+		ID:      request.ID,
+		Type:    FriendListResult,
+		Payload: payload,
+	})
+	return nil
+}
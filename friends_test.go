@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// mockAccountUser returns a logged in user of a new account named username
+func mockAccountUser(repository IRepository, username string) *User {
+	user := MockUserWithRepository(repository)
+	user.username = username
+	user.accountID = username
+	repository.SaveAccount(&Account{username, username, defaultRating, "", time.Now(), time.Time{}, nil})
+	repository.AddUser(user)
+	return user
+}
+
+func TestUser_Friends(t *testing.T) {
+	repository := InmemoryRepository()
+	alice, bob := mockAccountUser(repository, "alice"), mockAccountUser(repository, "bob")
+	expect := func(user *User, messageType string, payload map[string]string) {
+		t.Helper()
+		gotMsg := <-user.writeChan
+		if gotMsg.Type != messageType {
+			t.Fatalf("got %v, want %v", gotMsg, messageType)
+		}
+		for key, want := range payload {
+			if gotMsg.Payload[key] != want {
+				t.Errorf("%v %v = %v, want %v", messageType, key, gotMsg.Payload[key], want)
+			}
+		}
+	}
+
+	alice.resolveMessage(Message{Type: FriendRequest, Payload: map[string]string{"username": "BOB"}})
+	expect(bob, FriendRequested, map[string]string{"accountID": "alice", "username": "alice"})
+	alice.resolveMessage(Message{Type: FriendRequest, Payload: map[string]string{"accountID": "bob"}})
+	bob.resolveMessage(Message{Type: FriendList})
+	expect(bob, FriendListResult, map[string]string{
+		"friends":  "[]",
+		"incoming": `[{"accountID":"alice","username":"alice"}]`,
+		"outgoing": "[]",
+	})
+
+	bob.resolveMessage(Message{Type: FriendAccept, Payload: map[string]string{"accountID": "alice"}})
+	expect(alice, FriendAccepted, map[string]string{"accountID": "bob"})
+	expect(alice, Presence, map[string]string{"accountID": "bob", "status": PresenceOnline})
+	expect(bob, Presence, map[string]string{"accountID": "alice", "status": PresenceOnline})
+
+	// presence follows the search and the disconnect
+	alice.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expect(alice, GameSearchWait, nil)
+	expect(bob, Presence, map[string]string{"accountID": "alice", "status": PresenceSearching})
+	alice.resolveMessage(Message{Type: GameSearchOff})
+	expect(bob, Presence, map[string]string{"accountID": "alice", "status": PresenceOnline})
+	bob.close()
+	expect(alice, Presence, map[string]string{"accountID": "bob", "status": PresenceOffline})
+	alice.resolveMessage(Message{Type: FriendList})
+	expect(alice, FriendListResult, map[string]string{"friends": `[{"accountID":"bob","status":"offline","username":"bob"}]`})
+
+	alice.resolveMessage(Message{Type: FriendRequest, Payload: map[string]string{"accountID": "bob"}})
+	expect(alice, Error, map[string]string{"code": "ALREADY_FRIENDS"})
+	alice.resolveMessage(Message{Type: FriendRemove, Payload: map[string]string{"accountID": "bob"}})
+	if friendships := repository.Friendships("bob"); len(friendships) != 0 {
+		t.Errorf("friendships after FriendRemove = %v", friendships)
+	}
+	alice.resolveMessage(Message{Type: FriendDecline, Payload: map[string]string{"accountID": "bob"}})
+	expect(alice, Error, map[string]string{"code": "NO_FRIEND_REQUEST"})
+
+	guest := MockUserWithRepository(repository)
+	guest.resolveMessage(Message{Type: FriendRequest, Payload: map[string]string{"accountID": "alice"}})
+	expect(guest, Error, map[string]string{"code": "ACCOUNT_REQUIRED"})
+}
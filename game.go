@@ -3,19 +3,10 @@ package main
 import (
 	"log"
 	"strconv"
+	"sync"
+	"time"
 )
 
-var winSlice = [][3]int{
-	{1, 2, 3},
-	{4, 5, 6},
-	{7, 8, 9},
-	{1, 4, 7},
-	{2, 5, 8},
-	{3, 6, 9},
-	{1, 5, 9},
-	{3, 5, 7},
-}
-
 type GameUnit string
 
 const (
@@ -26,6 +17,8 @@ const (
 
 type GameField map[int]GameUnit
 
+// Game state is owned by the game goroutine started in NewGame,
+// exported methods hand their work over to it through commands.
 type Game struct {
 	uuid            string
 	users           []*User
@@ -34,69 +27,553 @@ type Game struct {
 	currentMoveUnit GameUnit
 	isOver          bool
 	field           GameField
+	variant         GameVariant
+	rules           Rules
+	startedAt       time.Time
+	moves           []MoveRecord
+	// clock state of games with a time control
+	clock         Clock
+	timeLeft      map[GameUnit]time.Duration
+	turnStartedAt time.Time
+	timer         Timer
+	// drawOfferedBy is the unit whose draw offer waits for an answer
+	drawOfferedBy GameUnit
+	// spectators receive the moves and the result of the game
+	spectators map[*User]bool
+	repository IRepository
+	commands   chan func()
+	quit       chan struct{}
+	stopOnce   *sync.Once
 }
 
-func (g *Game) Start() {
-	messageGameSearchOff := Message{
-		GameSearchOff,
-		map[string]string{},
-	}
-	messageGameStart := Message{
-		GameSearchStart,
-		map[string]string{
-			"gameUUID":      g.uuid,
-			"crossUserUUID": g.crossUser.uuid,
-			"zeroUserUUID":  g.zeroUser.uuid,
+func NewGame(repository IRepository, crossUser, zeroUser *User, variant GameVariant) *Game {
+	rules := variant.Rules()
+	game := &Game{
+		generateUUID(),
+		[]*User{crossUser, zeroUser},
+		crossUser,
+		zeroUser,
+		CROSS,
+		false,
+		rules.NewField(),
+		variant,
+		rules,
+		time.Now(),
+		nil,
+		realClock{},
+		map[GameUnit]time.Duration{
+			CROSS: variant.timeControl.total,
+			ZERO:  variant.timeControl.total,
 		},
+		time.Time{},
+		nil,
+		EMPTY,
+		map[*User]bool{},
+		repository,
+		make(chan func()),
+		make(chan struct{}),
+		&sync.Once{},
 	}
-	for _, user := range g.users {
-		user.writeChan <- messageGameSearchOff
-		user.writeChan <- messageGameStart
+	go game.loop()
+	return game
+}
+
+func (g *Game) loop() {
+	for {
+		select {
+		case command := <-g.commands:
+			command()
+		case <-g.quit:
+			return
+		}
 	}
-	log.Println("Game started", g.uuid)
 }
 
-func (g *Game) GetField() map[string]string {
-	field := map[string]string{}
-	for k, v := range g.field {
-		field[strconv.Itoa(k)] = string(v)
+// do runs command on the game goroutine and waits until it is done,
+// false means the game was stopped and command didn't run.
+func (g *Game) do(command func()) bool {
+	done := make(chan struct{})
+	select {
+	case g.commands <- func() {
+		command()
+		close(done)
+	}:
+		<-done
+		return true
+	case <-g.quit:
+		return false
 	}
-	return field
 }
 
-func (g *Game) CheckDraw() bool {
-	for _, value := range g.field {
-		if value == EMPTY {
-			return false
+// gameQueryTimeout bounds how long the daemons wait for a game, a busy
+// game is asked again on the next run
+var gameQueryTimeout = time.Second
+
+// doWithin is do for callers that must not wait for a busy game, false
+// means the game was stopped or command didn't finish within timeout.
+// Command must not touch the variables of the caller when it gives up.
+func (g *Game) doWithin(timeout time.Duration, command func()) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	done := make(chan struct{})
+	select {
+	case g.commands <- func() {
+		command()
+		close(done)
+	}:
+	case <-g.quit:
+		return false
+	case <-timer.C:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (g *Game) stopped() bool {
+	select {
+	case <-g.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop terminates the game goroutine, commands sent later are ignored
+func (g *Game) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.quit)
+	})
+}
+
+func (g *Game) Start() {
+	g.do(func() {
+		messageGameSearchOff := Message{
+			Type:    GameSearchOff,
+			Payload: map[string]string{},
 		}
+		messageGameStart := Message{
+			Type:    GameSearchStart,
+			Payload: g.describe(),
+		}
+		for _, user := range g.users {
+			user.send(messageGameSearchOff)
+			user.send(messageGameStart)
+		}
+		g.startClock()
+		log.Println("Game started", g.uuid)
+	})
+}
+
+// describe returns the payload telling clients who plays what and where
+func (g *Game) describe() map[string]string {
+	payload := map[string]string{
+		"gameUUID":      g.uuid,
+		"crossUserUUID": g.crossUser.uuid,
+		"zeroUserUUID":  g.zeroUser.uuid,
+		"game":          g.variant.rules,
+		"width":         strconv.Itoa(g.variant.width),
+		"height":        strconv.Itoa(g.variant.height),
+		"winLength":     strconv.Itoa(g.variant.winLength),
 	}
-	return true
+	g.variant.timeControl.describe(payload)
+	return payload
+}
+
+// snapshot returns the game description with the current turn and the field
+func (g *Game) snapshot() (map[string]string, map[string]string, bool) {
+	var state, field map[string]string
+	ok := g.do(func() {
+		state = g.describe()
+		state["currentMoveUnit"] = string(g.currentMoveUnit)
+		g.describeClock(state)
+		field = g.GetField()
+	})
+	return state, field, ok
+}
+
+// IsOver reports whether the game is finished, stopped games are finished
+// and busy ones are not
+func (g *Game) IsOver() bool {
+	isOver := false
+	if !g.doWithin(gameQueryTimeout, func() {
+		isOver = g.isOver
+	}) {
+		return g.stopped()
+	}
+	return isOver
+}
+
+// GetField, CheckDraw and CheckWinner read the field, they must be called
+// on the game goroutine.
+
+func (g *Game) GetField() map[string]string {
+	return g.rules.Serialize(g.field)
+}
+
+func (g *Game) CheckDraw() bool {
+	winner, over := g.rules.Outcome(g.field)
+	return over && winner == EMPTY
 }
 
 func (g *Game) CheckWinner() (GameUnit, bool) {
-	for _, combination := range winSlice {
-		if g.field[combination[0]] == EMPTY {
-			continue
+	winner, over := g.rules.Outcome(g.field)
+	return winner, over && winner != EMPTY
+}
+
+// startClock starts the timer of the unit about to move, it must be called
+// on the game goroutine
+func (g *Game) startClock() {
+	control := g.variant.timeControl
+	if !control.Enabled() || g.isOver {
+		return
+	}
+	unit, turn := g.currentMoveUnit, len(g.moves)
+	g.turnStartedAt = g.clock.Now()
+	g.timer = g.clock.AfterFunc(control.limit(g.timeLeft[unit]), func() {
+		g.do(func() {
+			// the move could have been made while the timer was firing
+			if len(g.moves) == turn {
+				g.timeout(unit)
+			}
+		})
+	})
+}
+
+// stopClock stops the timer and charges unit for the time of its move,
+// false means the time ran out before the move was made
+func (g *Game) stopClock(unit GameUnit) bool {
+	control := g.variant.timeControl
+	if !control.Enabled() || g.timer == nil {
+		return true
+	}
+	g.timer.Stop()
+	g.timer = nil
+	elapsed := g.clock.Now().Sub(g.turnStartedAt)
+	if elapsed >= control.limit(g.timeLeft[unit]) {
+		return false
+	}
+	if control.total > 0 {
+		g.timeLeft[unit] += control.increment - elapsed
+	}
+	return true
+}
+
+// describeClock adds the time left to payload when the game has a time
+// control, values are milliseconds
+func (g *Game) describeClock(payload map[string]string) {
+	control := g.variant.timeControl
+	if !control.Enabled() {
+		return
+	}
+	var elapsed time.Duration
+	if g.timer != nil {
+		elapsed = g.clock.Now().Sub(g.turnStartedAt)
+	}
+	left := func(d time.Duration) string {
+		if d < 0 {
+			d = 0
 		}
-		if g.field[combination[0]] == g.field[combination[1]] &&
-			g.field[combination[1]] == g.field[combination[2]] {
-			return g.field[combination[0]], true
+		return milliseconds(d)
+	}
+	if control.total > 0 {
+		crossLeft, zeroLeft := g.timeLeft[CROSS], g.timeLeft[ZERO]
+		if g.currentMoveUnit == CROSS {
+			crossLeft -= elapsed
+		} else {
+			zeroLeft -= elapsed
 		}
+		payload["crossTimeLeft"] = left(crossLeft)
+		payload["zeroTimeLeft"] = left(zeroLeft)
+	}
+	payload["moveTimeLeft"] = left(control.limit(g.timeLeft[g.currentMoveUnit]) - elapsed)
+}
+
+// replay returns the history of the game, it must be called on the game goroutine
+func (g *Game) replay(result string) *Replay {
+	return &Replay{
+		g.uuid,
+		g.variant,
+		g.crossUser.uuid,
+		g.zeroUser.uuid,
+		result,
+		g.startedAt,
+		append([]MoveRecord(nil), g.moves...),
+	}
+}
+
+func (g *Game) userOf(unit GameUnit) *User {
+	if unit == CROSS {
+		return g.crossUser
+	}
+	return g.zeroUser
+}
+
+// unitOf returns the unit user plays with, EMPTY for strangers
+func (g *Game) unitOf(user *User) GameUnit {
+	switch user {
+	case g.crossUser:
+		return CROSS
+	case g.zeroUser:
+		return ZERO
 	}
-	return EMPTY, false
+	return EMPTY
 }
 
-func (g *Game) GameOver() {
+// Move makes user's move, notifies the players and finishes the game
+// when the rules say it is over.
+func (g *Game) Move(user *User, position int) error {
+	var err error = errGameIsOver
+	g.do(func() {
+		err = g.move(user, position)
+	})
+	return err
+}
+
+func (g *Game) move(user *User, position int) error {
 	if g.isOver {
+		return errGameIsOver
+	}
+	unit := g.unitOf(user)
+	if unit == EMPTY || unit != g.currentMoveUnit {
+		return errNotYourTurn
+	}
+	err := g.rules.ValidateMove(g.field, unit, position)
+	if err != nil {
+		return err
+	}
+	if !g.stopClock(unit) {
+		g.timeout(unit)
+		return errGameIsOver
+	}
+	if g.drawOfferedBy != unit {
+		// moving instead of answering declines the draw offer
+		g.drawOfferedBy = EMPTY
+	}
+	cell := g.rules.ApplyMove(g.field, unit, position)
+	g.moves = append(g.moves, MoveRecord{unit, user.uuid, position, g.clock.Now()})
+	log.Printf("%v MOVED: %v\n", unit, cell)
+	if unit == CROSS {
+		g.currentMoveUnit = ZERO
+	} else {
+		g.currentMoveUnit = CROSS
+	}
+
+	winner, over := g.rules.Outcome(g.field)
+	g.isOver = over
+	g.startClock()
+	g.repository.SaveGame(g)
+
+	message := Message{
+		Type:    GameMoved,
+		Payload: g.GetField(),
+	}
+	g.broadcast(message)
+	if g.variant.timeControl.Enabled() {
+		clock := map[string]string{}
+		g.describeClock(clock)
+		g.broadcast(Message{
+			Type:    GameClock,
+			Payload: clock,
+		})
+	}
+	if !over {
+		return nil
+	}
+
+	g.rate(winner)
+	if winner != EMPTY {
+		message = Message{
+			Type: GameWinner,
+			Payload: map[string]string{
+				"winner": string(winner),
+			},
+		}
+	} else {
+		message = Message{
+			Type:    GameDraw,
+			Payload: map[string]string{},
+		}
+	}
+	g.finish(message, resultOf(winner))
+	return nil
+}
+
+// Resign ends the game in favor of the opponent of user
+func (g *Game) Resign(user *User) error {
+	var err error = errGameIsOver
+	g.do(func() {
+		unit := g.unitOf(user)
+		if g.isOver || unit == EMPTY {
+			return
+		}
+		g.isOver = true
+		g.repository.SaveGame(g)
+
+		winner := opponentOf(unit)
+		g.rate(winner)
+		g.finish(Message{
+			Type: GameWinner,
+			Payload: map[string]string{
+				"winner": string(winner),
+				"reason": "resign",
+			},
+		}, resultOf(winner))
+		err = nil
+	})
+	return err
+}
+
+// OfferDraw passes the draw offer of user to the opponent
+func (g *Game) OfferDraw(user *User) error {
+	var err error = errGameIsOver
+	g.do(func() {
+		unit := g.unitOf(user)
+		if g.isOver || unit == EMPTY {
+			return
+		}
+		err = nil
+		if g.drawOfferedBy == opponentOf(unit) {
+			// both want a draw
+			g.agreeDraw()
+			return
+		}
+		g.drawOfferedBy = unit
+		g.userOf(opponentOf(unit)).send(Message{
+			Type:    DrawOffer,
+			Payload: map[string]string{"userUUID": user.uuid},
+		})
+	})
+	return err
+}
+
+// AnswerDraw accepts or declines the draw offered to user
+func (g *Game) AnswerDraw(user *User, accept bool) error {
+	var err error = errGameIsOver
+	g.do(func() {
+		unit := g.unitOf(user)
+		if g.isOver || unit == EMPTY {
+			return
+		}
+		if g.drawOfferedBy != opponentOf(unit) {
+			err = errNoDrawOffer
+			return
+		}
+		err = nil
+		if accept {
+			g.agreeDraw()
+			return
+		}
+		g.drawOfferedBy = EMPTY
+		g.userOf(opponentOf(unit)).send(Message{
+			Type:    DrawDecline,
+			Payload: map[string]string{"userUUID": user.uuid},
+		})
+	})
+	return err
+}
+
+func (g *Game) agreeDraw() {
+	g.drawOfferedBy = EMPTY
+	g.isOver = true
+	g.repository.SaveGame(g)
+	g.rate(EMPTY)
+	g.finish(Message{
+		Type:    GameDraw,
+		Payload: map[string]string{"reason": "agreement"},
+	}, resultDraw)
+}
+
+// timeout ends the game in favor of the opponent of unit which ran out of time
+func (g *Game) timeout(unit GameUnit) {
+	if g.isOver || g.currentMoveUnit != unit {
 		return
 	}
+	if g.variant.timeControl.total > 0 {
+		g.timeLeft[unit] = 0
+	}
 	g.isOver = true
-	message := Message{
-		GameOver,
-		map[string]string{},
+	g.repository.SaveGame(g)
+	log.Printf("%v ran out of time in game %v", unit, g.uuid)
+
+	winner := opponentOf(unit)
+	g.rate(winner)
+	g.finish(Message{
+		Type: GameWinner,
+		Payload: map[string]string{
+			"winner": string(winner),
+			"reason": "timeout",
+		},
+	}, resultOf(winner))
+}
+
+// rate updates the ratings of the players of rated games
+func (g *Game) rate(winner GameUnit) {
+	if g.rated() {
+		updateRatings(g.crossUser, g.zeroUser, winner)
+	}
+}
+
+// rated tells whether the game counts for the ratings and the stats,
+// games against bots or between sessions of an account don't
+func (g *Game) rated() bool {
+	return !g.crossUser.bot && !g.zeroUser.bot && !sameAccount(g.crossUser, g.zeroUser)
+}
+
+// finish stores the replay, releases the players and tells them how
+// the game ended
+func (g *Game) finish(message Message, result string) {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	g.repository.SaveReplay(g.replay(result))
+	g.recordStats(result)
+	for _, player := range g.users {
+		player.setGameUUID("")
+		player.setLastGame(g.uuid)
+		g.repository.SaveUser(player)
+		player.saveAccount()
+		player.send(message)
+		player.announcePresence()
 	}
-	for _, user := range g.users {
-		user.currentGameUUID = ""
-		user.writeChan <- message
+	for spectator := range g.spectators {
+		spectator.stopWatching(g.uuid)
+		spectator.send(message)
 	}
+	g.spectators = map[*User]bool{}
+}
+
+// GameOver ends the game when user leaves it for good: a game without
+// moves is aborted, otherwise user forfeits it
+func (g *Game) GameOver(user *User) {
+	g.do(func() {
+		if g.isOver {
+			return
+		}
+		g.isOver = true
+		g.repository.SaveGame(g)
+		unit := g.unitOf(user)
+		if len(g.moves) == 0 || unit == EMPTY {
+			g.finish(Message{
+				Type:    GameOver,
+				Payload: map[string]string{},
+			}, resultAborted)
+			return
+		}
+
+		winner := opponentOf(unit)
+		g.rate(winner)
+		g.finish(Message{
+			Type: GameWinner,
+			Payload: map[string]string{
+				"winner": string(winner),
+				"reason": "abandoned",
+			},
+		}, resultOf(winner))
+	})
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGame_CheckWinner(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant GameVariant
+		moves   map[int]GameUnit
+		want    GameUnit
+		wantOk  bool
+	}{
+		{
+			"3x3 row",
+			GameVariant{TicTacToe, 3, 3, 3, TimeControl{}},
+			map[int]GameUnit{1: CROSS, 2: CROSS, 3: CROSS},
+			CROSS,
+			true,
+		},
+		{
+			"3x3 anti diagonal",
+			GameVariant{TicTacToe, 3, 3, 3, TimeControl{}},
+			map[int]GameUnit{3: ZERO, 5: ZERO, 7: ZERO},
+			ZERO,
+			true,
+		},
+		{
+			"row doesn't wrap to the next line",
+			GameVariant{TicTacToe, 4, 4, 3, TimeControl{}},
+			map[int]GameUnit{3: CROSS, 4: CROSS, 5: CROSS},
+			EMPTY,
+			false,
+		},
+		{
+			"4x4 column",
+			GameVariant{TicTacToe, 4, 4, 4, TimeControl{}},
+			map[int]GameUnit{2: ZERO, 6: ZERO, 10: ZERO, 14: ZERO},
+			ZERO,
+			true,
+		},
+		{
+			"15x15 five in a row on diagonal",
+			GameVariant{TicTacToe, 15, 15, 5, TimeControl{}},
+			map[int]GameUnit{17: CROSS, 33: CROSS, 49: CROSS, 65: CROSS, 81: CROSS},
+			CROSS,
+			true,
+		},
+		{
+			"15x15 four in a row isn't enough",
+			GameVariant{TicTacToe, 15, 15, 5, TimeControl{}},
+			map[int]GameUnit{1: CROSS, 2: CROSS, 3: CROSS, 4: CROSS, 6: CROSS},
+			EMPTY,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game := NewGame(nil, MockUser(), MockUser(), tt.variant)
+			for position, unit := range tt.moves {
+				game.field[position] = unit
+			}
+			got, ok := game.CheckWinner()
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Game.CheckWinner() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestParseGameVariant(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]string
+		want    GameVariant
+		wantOk  bool
+	}{
+		{
+			"default",
+			map[string]string{},
+			DefaultGameVariant,
+			true,
+		},
+		{
+			"five in a row",
+			map[string]string{"width": "15", "height": "15", "winLength": "5"},
+			GameVariant{TicTacToe, 15, 15, 5, TimeControl{}},
+			true,
+		},
+		{
+			"win length longer than the board",
+			map[string]string{"width": "4", "height": "4", "winLength": "5"},
+			GameVariant{TicTacToe, 4, 4, 5, TimeControl{}},
+			false,
+		},
+		{
+			"board too large",
+			map[string]string{"width": "100"},
+			GameVariant{TicTacToe, 100, 3, 3, TimeControl{}},
+			false,
+		},
+		{
+			"not a number",
+			map[string]string{"height": "four"},
+			DefaultGameVariant,
+			false,
+		},
+		{
+			"time control",
+			map[string]string{"time": "300", "increment": "5"},
+			GameVariant{TicTacToe, 3, 3, 3, TimeControl{300 * time.Second, 5 * time.Second, 0}},
+			true,
+		},
+		{
+			"increment without time",
+			map[string]string{"increment": "5", "moveTime": "30"},
+			GameVariant{TicTacToe, 3, 3, 3, TimeControl{0, 5 * time.Second, 30 * time.Second}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseGameVariant(tt.payload)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("ParseGameVariant() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGame_ConcurrentMoves(t *testing.T) {
+	repository := InmemoryRepository()
+	crossUser, zeroUser := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, user := range []*User{crossUser, zeroUser} {
+		repository.AddUser(user)
+		go func(user *User) {
+			for {
+				select {
+				case <-user.writeChan:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(user)
+	}
+
+	variant := GameVariant{TicTacToe, 15, 15, 15, TimeControl{}}
+	game := NewGame(repository, crossUser, zeroUser, variant)
+	crossUser.setGameUUID(game.uuid)
+	zeroUser.setGameUUID(game.uuid)
+	repository.AddGame(game)
+	go gameCleaner(repository, ctx, time.Tick(time.Millisecond))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(user *User) {
+			defer wg.Done()
+			for position := 1; position <= variant.width*variant.height; position++ {
+				user.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": strconv.Itoa(position)}})
+			}
+		}([]*User{crossUser, zeroUser}[i%2])
+	}
+	wg.Wait()
+
+	game.do(func() {
+		count := map[GameUnit]int{}
+		for _, unit := range game.field {
+			count[unit]++
+		}
+		if diff := count[CROSS] - count[ZERO]; diff != 0 && diff != 1 {
+			t.Errorf("moves weren't serialized: %v crosses, %v zeros", count[CROSS], count[ZERO])
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package main
 
-import "sync"
+import (
+	"strings"
+	"sync"
+	"time"
+)
 
 func InmemoryRepository() IRepository {
 	return &GameRepository{
@@ -12,21 +16,71 @@ func InmemoryRepository() IRepository {
 		&sync.RWMutex{},
 		&sync.RWMutex{},
 		&sync.RWMutex{},
+		make(map[string]*Replay),
+		[]string{},
+		&sync.RWMutex{},
+		make(map[string]*Invite),
+		&sync.RWMutex{},
+		make(map[string]*Challenge),
+		&sync.RWMutex{},
+		make(map[string][]*ChatEntry),
+		0,
+		&sync.RWMutex{},
+		make(map[string]*Account),
+		&sync.RWMutex{},
+		make(map[string]*PlayerStats),
+		&sync.RWMutex{},
+		make(map[int64]map[string]*Standing),
+		make(map[string]*Standing),
+		0,
+		&sync.RWMutex{},
+		make(map[string]map[string]*Friendship),
+		&sync.RWMutex{},
 	}
 }
 
+// maxInmemoryReplays bounds the replays kept in memory, the oldest go first
+const maxInmemoryReplays = 1000
+
+// standingDays is how many days of standings are kept in memory, enough
+// for the weekly leaderboard
+const standingDays = 7
+
 type GameRepository struct {
 	users                                                                     map[string]*User
 	usersInSearch                                                             map[string]*User
 	usersInSearchKeys                                                         []string
 	gameSessions                                                              map[string]*Game
 	usersMutex, usersInSearchMutex, usersInSearchKeysMutex, gameSessionsMutex *sync.RWMutex
+	replays                                                                   map[string]*Replay
+	replayKeys                                                                []string
+	replaysMutex                                                              *sync.RWMutex
+	invites                                                                   map[string]*Invite
+	invitesMutex                                                              *sync.RWMutex
+	challenges                                                                map[string]*Challenge
+	challengesMutex                                                           *sync.RWMutex
+	chatHistory                                                               map[string][]*ChatEntry
+	chatSeq                                                                   int64
+	chatMutex                                                                 *sync.RWMutex
+	accounts                                                                  map[string]*Account
+	accountsMutex                                                             *sync.RWMutex
+	stats                                                                     map[string]*PlayerStats
+	statsMutex                                                                *sync.RWMutex
+	// standings are summed per UTC day, for the last standingDays days, and
+	// for all time
+	dayStandings   map[int64]map[string]*Standing
+	allStandings   map[string]*Standing
+	lastResultDay  int64
+	standingsMutex *sync.RWMutex
+	// friendships are indexed by both accounts
+	friendships      map[string]map[string]*Friendship
+	friendshipsMutex *sync.RWMutex
 }
 
 func (gr *GameRepository) UserByUUID(uuid string) *User {
-	gr.usersMutex.Lock()
+	gr.usersMutex.RLock()
 	user, ok := gr.users[uuid]
-	gr.usersMutex.Unlock()
+	gr.usersMutex.RUnlock()
 	if ok {
 		return user
 	}
@@ -34,110 +88,377 @@ func (gr *GameRepository) UserByUUID(uuid string) *User {
 }
 
 func (gr *GameRepository) GameByUUID(uuid string) *Game {
-	gr.gameSessionsMutex.Lock()
+	gr.gameSessionsMutex.RLock()
 	game, ok := gr.gameSessions[uuid]
-	gr.gameSessionsMutex.Unlock()
+	gr.gameSessionsMutex.RUnlock()
 	if ok {
 		return game
 	}
 	return nil
 }
 
+// GameSessions returns a snapshot of the games, safe to range over
 func (gr *GameRepository) GameSessions() map[string]*Game {
-	return gr.gameSessions
+	gr.gameSessionsMutex.RLock()
+	defer gr.gameSessionsMutex.RUnlock()
+	games := make(map[string]*Game, len(gr.gameSessions))
+	for k, v := range gr.gameSessions {
+		games[k] = v
+	}
+	return games
 }
 
+// UsersInSearch returns a snapshot of the users in search, safe to range over
 func (gr *GameRepository) UsersInSearch() map[string]*User {
-	return gr.usersInSearch
+	gr.usersInSearchMutex.RLock()
+	defer gr.usersInSearchMutex.RUnlock()
+	users := make(map[string]*User, len(gr.usersInSearch))
+	for k, v := range gr.usersInSearch {
+		users[k] = v
+	}
+	return users
 }
 
 func (gr *GameRepository) UsersInSearchInsertionOrder() []*User {
-	var slice []*User
+	gr.usersInSearchKeysMutex.RLock()
+	defer gr.usersInSearchKeysMutex.RUnlock()
+	gr.usersInSearchMutex.RLock()
+	defer gr.usersInSearchMutex.RUnlock()
+	slice := []*User{}
 	for _, key := range gr.usersInSearchKeys {
-		slice = append(slice, gr.usersInSearch[key])
+		user, ok := gr.usersInSearch[key]
+		if !ok {
+			continue
+		}
+		slice = append(slice, user)
 	}
 	return slice
 }
 
+// Users returns a snapshot of the users, safe to range over
 func (gr *GameRepository) Users() map[string]*User {
-	return gr.users
+	gr.usersMutex.RLock()
+	defer gr.usersMutex.RUnlock()
+	users := make(map[string]*User, len(gr.users))
+	for k, v := range gr.users {
+		users[k] = v
+	}
+	return users
 }
 
 func (gr *GameRepository) AddUser(user *User) {
-	gr.usersMutex.RLock()
-	_, ok := gr.users[user.uuid]
-	gr.usersMutex.RUnlock()
-	if !ok {
-		gr.usersMutex.Lock()
+	gr.usersMutex.Lock()
+	if _, ok := gr.users[user.uuid]; !ok {
 		gr.users[user.uuid] = user
-		gr.usersMutex.Unlock()
 	}
+	gr.usersMutex.Unlock()
 }
 
 func (gr *GameRepository) RemoveUser(user *User) {
+	gr.removeUserInSearchKey(user)
+
+	gr.usersMutex.Lock()
+	delete(gr.users, user.uuid)
+	gr.usersMutex.Unlock()
+}
+
+func (gr *GameRepository) AddUserInSearch(user *User) {
+	gr.usersInSearchKeysMutex.Lock()
+	defer gr.usersInSearchKeysMutex.Unlock()
+	gr.usersInSearchMutex.Lock()
+	defer gr.usersInSearchMutex.Unlock()
+	if _, ok := gr.usersInSearch[user.uuid]; !ok {
+		gr.usersInSearch[user.uuid] = user
+		gr.usersInSearchKeys = append(gr.usersInSearchKeys, user.uuid)
+	}
+}
+
+func (gr *GameRepository) RemoveUserInSearch(user *User) {
+	gr.usersInSearchMutex.Lock()
+	delete(gr.usersInSearch, user.uuid)
+	gr.usersInSearchMutex.Unlock()
+
+	gr.removeUserInSearchKey(user)
+}
+
+func (gr *GameRepository) removeUserInSearchKey(user *User) {
+	gr.usersInSearchKeysMutex.Lock()
+	defer gr.usersInSearchKeysMutex.Unlock()
 	var newSlice []string
-	gr.usersInSearchKeysMutex.RUnlock()
 	for _, v := range gr.usersInSearchKeys {
 		if v == user.uuid {
 			continue
 		}
 		newSlice = append(newSlice, v)
 	}
-	gr.usersInSearchKeysMutex.RUnlock()
-
-	gr.usersInSearchKeysMutex.Lock()
 	gr.usersInSearchKeys = newSlice
-	gr.usersInSearchKeysMutex.Unlock()
+}
 
-	gr.usersMutex.Lock()
-	_, ok := gr.users[user.uuid]
-	if ok {
-		delete(gr.users, user.uuid)
+func (gr *GameRepository) AddGame(game *Game) {
+	gr.gameSessionsMutex.Lock()
+	if _, ok := gr.gameSessions[game.uuid]; !ok {
+		gr.gameSessions[game.uuid] = game
 	}
-	gr.usersMutex.Unlock()
+	gr.gameSessionsMutex.Unlock()
 }
 
-func (gr *GameRepository) AddUserInSearch(user *User) {
-	gr.usersInSearchMutex.RLock()
-	_, ok := gr.usersInSearch[user.uuid]
-	gr.usersInSearchKeys = append(gr.usersInSearchKeys, user.uuid)
-	gr.usersInSearchMutex.RUnlock()
-	if !ok {
-		gr.usersInSearchMutex.Lock()
-		gr.usersInSearch[user.uuid] = user
-		gr.usersInSearchMutex.Unlock()
+func (gr *GameRepository) RemoveGame(game *Game) {
+	gr.gameSessionsMutex.Lock()
+	delete(gr.gameSessions, game.uuid)
+	gr.gameSessionsMutex.Unlock()
+	gr.chatMutex.Lock()
+	delete(gr.chatHistory, gameChannel(game.uuid))
+	gr.chatMutex.Unlock()
+}
+
+// SaveUser is a no-op, users live only in memory
+func (gr *GameRepository) SaveUser(user *User) {}
+
+// SaveGame is a no-op, games live only in memory
+func (gr *GameRepository) SaveGame(game *Game) {}
+
+func (gr *GameRepository) SaveReplay(replay *Replay) {
+	gr.replaysMutex.Lock()
+	defer gr.replaysMutex.Unlock()
+	if _, ok := gr.replays[replay.gameUUID]; !ok {
+		gr.replayKeys = append(gr.replayKeys, replay.gameUUID)
+	}
+	gr.replays[replay.gameUUID] = replay
+	for len(gr.replayKeys) > maxInmemoryReplays {
+		delete(gr.replays, gr.replayKeys[0])
+		gr.replayKeys = gr.replayKeys[1:]
 	}
 }
 
-func (gr *GameRepository) RemoveUserInSearch(user *User) {
-	gr.usersInSearchMutex.RLock()
-	_, ok := gr.usersInSearch[user.uuid]
-	gr.usersInSearchMutex.RUnlock()
-	if ok {
-		gr.usersInSearchMutex.Lock()
-		delete(gr.usersInSearch, user.uuid)
-		gr.usersInSearchMutex.Unlock()
+func (gr *GameRepository) ReplayByUUID(gameUUID string) *Replay {
+	gr.replaysMutex.RLock()
+	defer gr.replaysMutex.RUnlock()
+	return gr.replays[gameUUID]
+}
+
+func (gr *GameRepository) InviteByCode(code string) *Invite {
+	gr.invitesMutex.RLock()
+	defer gr.invitesMutex.RUnlock()
+	return gr.invites[code]
+}
+
+// Invites returns a snapshot of the invites, safe to range over
+func (gr *GameRepository) Invites() map[string]*Invite {
+	gr.invitesMutex.RLock()
+	defer gr.invitesMutex.RUnlock()
+	invites := make(map[string]*Invite, len(gr.invites))
+	for k, v := range gr.invites {
+		invites[k] = v
 	}
+	return invites
 }
 
-func (gr *GameRepository) AddGame(game *Game) {
-	gr.gameSessionsMutex.RLock()
-	_, ok := gr.gameSessions[game.uuid]
-	gr.gameSessionsMutex.RUnlock()
+func (gr *GameRepository) AddInvite(invite *Invite) {
+	gr.invitesMutex.Lock()
+	if _, ok := gr.invites[invite.code]; !ok {
+		gr.invites[invite.code] = invite
+	}
+	gr.invitesMutex.Unlock()
+}
+
+func (gr *GameRepository) RemoveInvite(invite *Invite) {
+	gr.invitesMutex.Lock()
+	delete(gr.invites, invite.code)
+	gr.invitesMutex.Unlock()
+}
+
+func (gr *GameRepository) ChallengeByID(id string) *Challenge {
+	gr.challengesMutex.RLock()
+	defer gr.challengesMutex.RUnlock()
+	return gr.challenges[id]
+}
+
+// Challenges returns a snapshot of the challenges, safe to range over
+func (gr *GameRepository) Challenges() map[string]*Challenge {
+	gr.challengesMutex.RLock()
+	defer gr.challengesMutex.RUnlock()
+	challenges := make(map[string]*Challenge, len(gr.challenges))
+	for k, v := range gr.challenges {
+		challenges[k] = v
+	}
+	return challenges
+}
+
+func (gr *GameRepository) AddChallenge(challenge *Challenge) {
+	gr.challengesMutex.Lock()
+	gr.challenges[challenge.id] = challenge
+	gr.challengesMutex.Unlock()
+}
+
+func (gr *GameRepository) RemoveChallenge(challenge *Challenge) {
+	gr.challengesMutex.Lock()
+	delete(gr.challenges, challenge.id)
+	gr.challengesMutex.Unlock()
+}
+
+// AddChatEntry keeps the last chatHistorySize entries of the last
+// chatHistoryChannels channels
+func (gr *GameRepository) AddChatEntry(entry *ChatEntry) {
+	gr.chatMutex.Lock()
+	defer gr.chatMutex.Unlock()
+	gr.chatSeq++
+	entry.seq = gr.chatSeq
+	if _, ok := gr.chatHistory[entry.channel]; !ok && len(gr.chatHistory) >= chatHistoryChannels {
+		idle, idleSeq := "", gr.chatSeq
+		for channel, entries := range gr.chatHistory {
+			if last := entries[len(entries)-1].seq; last < idleSeq {
+				idle, idleSeq = channel, last
+			}
+		}
+		delete(gr.chatHistory, idle)
+	}
+	entries := append(gr.chatHistory[entry.channel], entry)
+	if len(entries) > chatHistorySize {
+		entries = append([]*ChatEntry(nil), entries[len(entries)-chatHistorySize:]...)
+	}
+	gr.chatHistory[entry.channel] = entries
+}
+
+func (gr *GameRepository) ChatHistory(channel string, before int64, limit int) []*ChatEntry {
+	gr.chatMutex.RLock()
+	defer gr.chatMutex.RUnlock()
+	entries := gr.chatHistory[channel]
+	end := len(entries)
+	for end > 0 && before > 0 && entries[end-1].seq >= before {
+		end--
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	return append([]*ChatEntry{}, entries[start:end]...)
+}
+
+func (gr *GameRepository) AccountByID(id string) *Account {
+	gr.accountsMutex.RLock()
+	defer gr.accountsMutex.RUnlock()
+	return gr.accounts[id]
+}
+
+func (gr *GameRepository) AccountByUsername(username string) *Account {
+	gr.accountsMutex.RLock()
+	defer gr.accountsMutex.RUnlock()
+	for _, account := range gr.accounts {
+		if strings.EqualFold(account.username, username) {
+			return account
+		}
+	}
+	return nil
+}
+
+// SaveAccount replaces the stored account, accounts are never changed in
+// place so they may be read without locks
+func (gr *GameRepository) SaveAccount(account *Account) {
+	gr.accountsMutex.Lock()
+	gr.accounts[account.id] = account
+	gr.accountsMutex.Unlock()
+}
+
+func (gr *GameRepository) StatsByAccount(accountID string) *PlayerStats {
+	gr.statsMutex.RLock()
+	defer gr.statsMutex.RUnlock()
+	return gr.stats[accountID]
+}
+
+// SaveStats replaces the stored stats like SaveAccount
+func (gr *GameRepository) SaveStats(stats *PlayerStats) {
+	gr.statsMutex.Lock()
+	gr.stats[stats.accountID] = stats
+	gr.statsMutex.Unlock()
+}
+
+func (gr *GameRepository) AddGameResult(result *GameResult) {
+	finishedAt := result.finishedAt.UTC()
+	day := time.Date(finishedAt.Year(), finishedAt.Month(), finishedAt.Day(), 0, 0, 0, 0, time.UTC).Unix()
+	gr.standingsMutex.Lock()
+	defer gr.standingsMutex.Unlock()
+	addStanding(gr.allStandings, result)
+	if day > gr.lastResultDay {
+		gr.lastResultDay = day
+		for other := range gr.dayStandings {
+			if other <= day-standingDays*24*60*60 {
+				delete(gr.dayStandings, other)
+			}
+		}
+	}
+	if day <= gr.lastResultDay-standingDays*24*60*60 {
+		return
+	}
+	if gr.dayStandings[day] == nil {
+		gr.dayStandings[day] = make(map[string]*Standing)
+	}
+	addStanding(gr.dayStandings[day], result)
+}
+
+func addStanding(standings map[string]*Standing, result *GameResult) {
+	standing, ok := standings[result.accountID]
 	if !ok {
-		gr.gameSessionsMutex.Lock()
-		gr.gameSessions[game.uuid] = game
-		gr.gameSessionsMutex.Unlock()
+		standing = &Standing{accountID: result.accountID}
+		standings[result.accountID] = standing
 	}
+	standing.add(result)
 }
 
-func (gr *GameRepository) RemoveGame(game *Game) {
-	gr.gameSessionsMutex.RLock()
-	_, ok := gr.gameSessions[game.uuid]
-	gr.gameSessionsMutex.RUnlock()
-	if ok {
-		gr.gameSessionsMutex.Lock()
-		delete(gr.gameSessions, game.uuid)
-		gr.gameSessionsMutex.Unlock()
+// Standings merges the days since the time, older days aren't kept
+func (gr *GameRepository) Standings(since time.Time) []*Standing {
+	gr.standingsMutex.RLock()
+	defer gr.standingsMutex.RUnlock()
+	standings := []*Standing{}
+	if since.IsZero() {
+		for _, standing := range gr.allStandings {
+			copied := *standing
+			standings = append(standings, &copied)
+		}
+		return standings
 	}
+	merged := map[string]*Standing{}
+	for day, dayStandings := range gr.dayStandings {
+		if day < since.Unix() {
+			continue
+		}
+		for accountID, standing := range dayStandings {
+			if merged[accountID] == nil {
+				merged[accountID] = &Standing{accountID: accountID}
+			}
+			merged[accountID].merge(standing)
+		}
+	}
+	for _, standing := range merged {
+		standings = append(standings, standing)
+	}
+	return standings
+}
+
+func (gr *GameRepository) Friendships(accountID string) []*Friendship {
+	gr.friendshipsMutex.RLock()
+	defer gr.friendshipsMutex.RUnlock()
+	friendships := []*Friendship{}
+	for _, friendship := range gr.friendships[accountID] {
+		friendships = append(friendships, friendship)
+	}
+	return friendships
+}
+
+// SaveFriendship replaces the friendship of the accounts like SaveAccount
+func (gr *GameRepository) SaveFriendship(friendship *Friendship) {
+	gr.friendshipsMutex.Lock()
+	defer gr.friendshipsMutex.Unlock()
+	for _, accountID := range []string{friendship.requesterID, friendship.addresseeID} {
+		if gr.friendships[accountID] == nil {
+			gr.friendships[accountID] = map[string]*Friendship{}
+		}
+		gr.friendships[accountID][friendship.other(accountID)] = friendship
+	}
+}
+
+func (gr *GameRepository) RemoveFriendship(friendship *Friendship) {
+	gr.friendshipsMutex.Lock()
+	defer gr.friendshipsMutex.Unlock()
+	delete(gr.friendships[friendship.requesterID], friendship.addresseeID)
+	delete(gr.friendships[friendship.addresseeID], friendship.requesterID)
 }
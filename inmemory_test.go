@@ -2,744 +2,451 @@ package main
 
 import (
 	"reflect"
-	"sync"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func MockUser() *User {
-	return &User{
-		generateUUID(),
-		generateUUID(),
-		"",
-		nil,
-		make(chan Message),
-		nil,
-	}
+	user := NewUser(nil)
+	user.username = generateUUID()
+	user.writeChan = make(chan Message)
+	return user
 }
 
 func MockGame(crossUser, zeroUser *User) *Game {
-	return &Game{
-		generateUUID(),
-		[]*User{crossUser, zeroUser},
-		crossUser,
-		zeroUser,
-		"CROSS",
-		false,
-		GameField{},
+	return NewGame(crossUser.repository, crossUser, zeroUser, DefaultGameVariant)
+}
+
+// repositoryBackends are the IRepository implementations the repository
+// contract tests below run against
+var repositoryBackends = []struct {
+	name string
+	open func(t *testing.T) (IRepository, func())
+}{
+	{"inmemory", func(t *testing.T) (IRepository, func()) {
+		return InmemoryRepository(), func() {}
+	}},
+	{"sqlite", func(t *testing.T) (IRepository, func()) {
+		return MockSqliteRepository(t)
+	}},
+}
+
+// repositoryState is what a repository holds before a contract test case
+type repositoryState struct {
+	users         []*User
+	usersInSearch []*User
+	gameSessions  []*Game
+}
+
+// runRepositoryTest runs test against a repository of every backend filled
+// with state
+func runRepositoryTest(t *testing.T, name string, state repositoryState, test func(t *testing.T, repository IRepository)) {
+	for _, backend := range repositoryBackends {
+		t.Run(backend.name+"/"+name, func(t *testing.T) {
+			repository, cleanup := backend.open(t)
+			defer cleanup()
+			for _, user := range state.users {
+				repository.AddUser(user)
+			}
+			for _, user := range state.usersInSearch {
+				repository.AddUserInSearch(user)
+			}
+			for _, game := range state.gameSessions {
+				repository.AddGame(game)
+			}
+			test(t, repository)
+		})
 	}
 }
 
 func TestGameRepository_UserByUUID(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		uuid string
-	}
 	mockUser := MockUser()
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   *User
+		name  string
+		state repositoryState
+		uuid  string
+		want  *User
 	}{
 		{
 			"user exists",
-			fields{
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUser.uuid,
-			},
+			repositoryState{users: []*User{mockUser}},
+			mockUser.uuid,
 			mockUser,
 		},
 		{
 			"user doesn't exists",
-			fields{
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				generateUUID(),
-			},
+			repositoryState{users: []*User{mockUser}},
+			generateUUID(),
 			nil,
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			if got := gr.UserByUUID(tt.args.uuid); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.UserByUUID() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			if got := repository.UserByUUID(tt.uuid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UserByUUID() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_GameByUUID(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		uuid string
-	}
 	mockCrossUser, mockZeroUser := MockUser(), MockUser()
 	mockGame := MockGame(mockCrossUser, mockZeroUser)
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   *Game
+		name  string
+		state repositoryState
+		uuid  string
+		want  *Game
 	}{
 		{
 			"game found",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{
-					mockGame.uuid: mockGame,
-				},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockGame.uuid,
-			},
+			repositoryState{gameSessions: []*Game{mockGame}},
+			mockGame.uuid,
 			mockGame,
 		},
 		{
 			"no games found",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{
-					mockGame.uuid: mockGame,
-				},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				generateUUID(),
-			},
+			repositoryState{gameSessions: []*Game{mockGame}},
+			generateUUID(),
 			nil,
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			if got := gr.GameByUUID(tt.args.uuid); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.GameByUUID() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			if got := repository.GameByUUID(tt.uuid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GameByUUID() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_GameSessions(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
 	mockCrossUser, mockZeroUser := MockUser(), MockUser()
 	mockGame := MockGame(mockCrossUser, mockZeroUser)
 	tests := []struct {
-		name   string
-		fields fields
-		want   map[string]*Game
+		name  string
+		state repositoryState
+		want  map[string]*Game
 	}{
 		{
 			"no games found",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{
-					mockGame.uuid: mockGame,
-				},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
+			repositoryState{gameSessions: []*Game{mockGame}},
 			map[string]*Game{
 				mockGame.uuid: mockGame,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			if got := gr.GameSessions(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.GameSessions() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			if got := repository.GameSessions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GameSessions() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_UsersInSearch(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
 	mockUser := MockUser()
 	tests := []struct {
-		name   string
-		fields fields
-		want   map[string]*User
+		name  string
+		state repositoryState
+		want  map[string]*User
 	}{
 		{
 			"user exists",
-			fields{
-				map[string]*User{},
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
+			repositoryState{usersInSearch: []*User{mockUser}},
 			map[string]*User{
 				mockUser.uuid: mockUser,
 			},
 		},
 		{
 			"user doesn't exists",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
+			repositoryState{},
 			map[string]*User{},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			if got := gr.UsersInSearch(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.UsersInSearch() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			if got := repository.UsersInSearch(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UsersInSearch() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_UsersInSearchInsertionOrder(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		usersInSerachKeys  []string
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	mockUser := MockUser()
+	mockUser, mockUserSecond := MockUser(), MockUser()
 	tests := []struct {
 		name   string
-		fields fields
+		state  repositoryState
+		remove []*User
 		want   []*User
 	}{
 		{
 			"user exists",
-			fields{
-				map[string]*User{},
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				[]string{
-					mockUser.uuid,
-				},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			[]*User{
-				mockUser,
-			},
+			repositoryState{usersInSearch: []*User{mockUser}},
+			nil,
+			[]*User{mockUser},
+		},
+		{
+			"users in insertion order",
+			repositoryState{usersInSearch: []*User{mockUserSecond, mockUser}},
+			nil,
+			[]*User{mockUserSecond, mockUser},
+		},
+		{
+			"user removed",
+			repositoryState{usersInSearch: []*User{mockUser, mockUserSecond}},
+			[]*User{mockUser},
+			[]*User{mockUserSecond},
 		},
 		{
 			"user doesn't exists",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				[]string{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
+			repositoryState{},
+			nil,
 			[]*User{},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				usersInSearchKeys:  tt.fields.usersInSerachKeys,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			for _, user := range tt.remove {
+				repository.RemoveUserInSearch(user)
 			}
-			if got := gr.UsersInSearchInsertionOrder(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.UsersInSearchInsertionOrder() = %T, want %T", got, tt.want)
+			if got := repository.UsersInSearchInsertionOrder(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UsersInSearchInsertionOrder() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_AddUser(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		user *User
-	}
 	mockUser := MockUser()
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   map[string]*User
+		name  string
+		state repositoryState
+		user  *User
+		want  map[string]*User
 	}{
 		{
 			"user added successfully",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUser,
-			},
+			repositoryState{},
+			mockUser,
 			map[string]*User{
 				mockUser.uuid: mockUser,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			gr.AddUser(tt.args.user)
-			if got := gr.Users(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.AddUser() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			repository.AddUser(tt.user)
+			if got := repository.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AddUser() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_RemoveUser(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		user *User
-	}
 	mockUser := MockUser()
 	mockUserAdditional := MockUser()
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   map[string]*User
+		name  string
+		state repositoryState
+		user  *User
+		want  map[string]*User
 	}{
 		{
 			"user removed successfully",
-			fields{
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUser,
-			},
+			repositoryState{users: []*User{mockUser}},
+			mockUser,
 			map[string]*User{},
 		},
 		{
 			"no users removed",
-			fields{
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUserAdditional,
-			},
+			repositoryState{users: []*User{mockUser}},
+			mockUserAdditional,
 			map[string]*User{
 				mockUser.uuid: mockUser,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			gr.RemoveUser(tt.args.user)
-			if got := gr.Users(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.RemoveUser() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			repository.RemoveUser(tt.user)
+			if got := repository.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RemoveUser() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_AddUserInSearch(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		user *User
-	}
 	mockUser := MockUser()
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   map[string]*User
+		name  string
+		state repositoryState
+		user  *User
+		want  map[string]*User
 	}{
 		{
 			"no users removed",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUser,
-			},
+			repositoryState{},
+			mockUser,
 			map[string]*User{
 				mockUser.uuid: mockUser,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			gr.AddUserInSearch(tt.args.user)
-			gr.AddUser(tt.args.user)
-			if got := gr.UsersInSearch(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.AddUserInSearch() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			repository.AddUserInSearch(tt.user)
+			repository.AddUser(tt.user)
+			if got := repository.UsersInSearch(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AddUserInSearch() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_RemoveUserInSearch(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		user *User
-	}
 	mockUser := MockUser()
 	mockUserAdditional := MockUser()
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   map[string]*User
+		name  string
+		state repositoryState
+		user  *User
+		want  map[string]*User
 	}{
 		{
 			"user removed",
-			fields{
-				map[string]*User{},
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUser,
-			},
+			repositoryState{usersInSearch: []*User{mockUser}},
+			mockUser,
 			map[string]*User{},
 		},
 		{
 			"no users removed",
-			fields{
-				map[string]*User{},
-				map[string]*User{
-					mockUser.uuid: mockUser,
-				},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockUserAdditional,
-			},
+			repositoryState{usersInSearch: []*User{mockUser}},
+			mockUserAdditional,
 			map[string]*User{
 				mockUser.uuid: mockUser,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			gr.RemoveUserInSearch(tt.args.user)
-			gr.AddUser(tt.args.user)
-			if got := gr.UsersInSearch(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.RemoveUserInSearch() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			repository.RemoveUserInSearch(tt.user)
+			repository.AddUser(tt.user)
+			if got := repository.UsersInSearch(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RemoveUserInSearch() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_AddGame(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		game *Game
-	}
 	mockCrossUser, mockZeroUser := MockUser(), MockUser()
 	mockGame := MockGame(mockCrossUser, mockZeroUser)
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   map[string]*Game
+		name  string
+		state repositoryState
+		game  *Game
+		want  map[string]*Game
 	}{
 		{
 			"game added",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockGame,
-			},
+			repositoryState{},
+			mockGame,
 			map[string]*Game{
 				mockGame.uuid: mockGame,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			gr.AddGame(tt.args.game)
-			if got := gr.GameSessions(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.AddGame() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			repository.AddGame(tt.game)
+			if got := repository.GameSessions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AddGame() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
 func TestGameRepository_RemoveGame(t *testing.T) {
-	type fields struct {
-		users              map[string]*User
-		usersInSearch      map[string]*User
-		gameSessions       map[string]*Game
-		usersMutex         *sync.RWMutex
-		usersInSearchMutex *sync.RWMutex
-		gameSessionsMutex  *sync.RWMutex
-	}
-	type args struct {
-		game *Game
-	}
 	mockCrossUser, mockZeroUser := MockUser(), MockUser()
 	mockGame := MockGame(mockCrossUser, mockZeroUser)
 	mockGameAdditional := MockGame(mockCrossUser, mockZeroUser)
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   map[string]*Game
+		name  string
+		state repositoryState
+		game  *Game
+		want  map[string]*Game
 	}{
 		{
 			"game removed",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{
-					mockGame.uuid: mockGame,
-				},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockGame,
-			},
+			repositoryState{gameSessions: []*Game{mockGame}},
+			mockGame,
 			map[string]*Game{},
 		},
 		{
 			"no games removed",
-			fields{
-				map[string]*User{},
-				map[string]*User{},
-				map[string]*Game{
-					mockGame.uuid: mockGame,
-				},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-				&sync.RWMutex{},
-			},
-			args{
-				mockGameAdditional,
-			},
+			repositoryState{gameSessions: []*Game{mockGame}},
+			mockGameAdditional,
 			map[string]*Game{
 				mockGame.uuid: mockGame,
 			},
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gr := &GameRepository{
-				users:              tt.fields.users,
-				usersInSearch:      tt.fields.usersInSearch,
-				gameSessions:       tt.fields.gameSessions,
-				usersMutex:         tt.fields.usersMutex,
-				usersInSearchMutex: tt.fields.usersInSearchMutex,
-				gameSessionsMutex:  tt.fields.gameSessionsMutex,
-			}
-			gr.RemoveGame(tt.args.game)
-			if got := gr.GameSessions(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GameRepository.RemoveGame() = %v, want %v", got, tt.want)
+		runRepositoryTest(t, tt.name, tt.state, func(t *testing.T, repository IRepository) {
+			repository.RemoveGame(tt.game)
+			if got := repository.GameSessions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RemoveGame() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestGameRepository_ChatHistory(t *testing.T) {
+	gr := InmemoryRepository()
+	for i := 0; i < chatHistorySize+5; i++ {
+		gr.AddChatEntry(&ChatEntry{0, "room:gophers", "uuid", "", "gopher", strconv.Itoa(i), time.Now()})
+	}
+	gr.AddChatEntry(&ChatEntry{0, "room:other", "uuid", "", "gopher", "other", time.Now()})
+
+	page := gr.ChatHistory("room:gophers", 0, 3)
+	if len(page) != 3 || page[0].text != strconv.Itoa(chatHistorySize+2) || page[2].seq != int64(chatHistorySize+5) {
+		t.Fatalf("GameRepository.ChatHistory() newest page = %v", page)
+	}
+	page = gr.ChatHistory("room:gophers", page[0].seq, chatHistorySize)
+	if len(page) != chatHistorySize-3 || page[0].text != "5" {
+		t.Errorf("GameRepository.ChatHistory() kept %v entries from %v, want %v from 5", len(page), page[0].text, chatHistorySize-3)
+	}
+	if page = gr.ChatHistory("room:other", 0, 10); len(page) != 1 || page[0].seq != int64(chatHistorySize+6) {
+		t.Errorf("GameRepository.ChatHistory() other channel = %v", page)
+	}
+}
+
+func TestGameRepository_RemoveGameChat(t *testing.T) {
+	game := MockGame(MockUser(), MockUser())
+	runRepositoryTest(t, "game room", repositoryState{gameSessions: []*Game{game}}, func(t *testing.T, repository IRepository) {
+		repository.AddChatEntry(&ChatEntry{0, gameChannel(game.uuid), "uuid", "", "gopher", "gg", time.Now()})
+		repository.RemoveGame(game)
+		if page := repository.ChatHistory(gameChannel(game.uuid), 0, 10); len(page) != 0 {
+			t.Errorf("ChatHistory() of a removed game = %v", page)
+		}
+	})
+}
+
+func TestGameRepository_ChatHistoryChannels(t *testing.T) {
+	repository := InmemoryRepository()
+	for i := 0; i <= chatHistoryChannels; i++ {
+		repository.AddChatEntry(&ChatEntry{0, "room:" + strconv.Itoa(i), "uuid", "", "gopher", "hi", time.Now()})
+	}
+	if page := repository.ChatHistory("room:0", 0, 10); len(page) != 0 {
+		t.Errorf("ChatHistory() of the channel idle the longest = %v", page)
+	}
+	if page := repository.ChatHistory("room:1", 0, 10); len(page) != 1 {
+		t.Errorf("ChatHistory() = %v, want an entry", page)
+	}
+}
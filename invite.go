@@ -0,0 +1,86 @@
+package main
+
+import "time"
+
+// inviteTTL is how long an invite code can be used to join a private game
+var inviteTTL = 10 * time.Minute
+
+// Invite is a private game waiting for the player the code was shared with
+type Invite struct {
+	code        string
+	creatorUUID string
+	variant     GameVariant
+	expiresAt   time.Time
+}
+
+func NewInvite(creator *User, variant GameVariant, now time.Time) *Invite {
+	return &Invite{
+		generateInviteCode(),
+		creator.uuid,
+		variant,
+		now.Add(inviteTTL),
+	}
+}
+
+func (i *Invite) Expired(now time.Time) bool {
+	return !now.Before(i.expiresAt)
+}
+
+// createPrivate replaces the previous invite of the user with a new one
+func (u *User) createPrivate(variant GameVariant) (*Invite, error) {
+	if u.gameUUID() != "" {
+		return nil, errAlreadyInGame
+	}
+	startMutex.Lock()
+	defer startMutex.Unlock()
+	for _, invite := range u.repository.Invites() {
+		if invite.creatorUUID == u.uuid {
+			u.repository.RemoveInvite(invite)
+		}
+	}
+	invite := NewInvite(u, variant, time.Now())
+	for u.repository.InviteByCode(invite.code) != nil {
+		invite.code = generateInviteCode()
+	}
+	u.repository.AddInvite(invite)
+	return invite, nil
+}
+
+// joinPrivate starts the game of the invite, the creator plays crosses
+func (u *User) joinPrivate(code string) error {
+	if u.gameUUID() != "" {
+		return errAlreadyInGame
+	}
+	startMutex.Lock()
+	game, err := u.createInvitedGame(code)
+	startMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	game.launch()
+	return nil
+}
+
+// createInvitedGame consumes the invite, the caller holds startMutex
+func (u *User) createInvitedGame(code string) (*Game, error) {
+	invite := u.repository.InviteByCode(code)
+	if invite == nil || invite.Expired(time.Now()) {
+		return nil, errInviteNotFound
+	}
+	if invite.creatorUUID == u.uuid {
+		return nil, errOwnInvite
+	}
+	creator := u.repository.UserByUUID(invite.creatorUUID)
+	if creator == nil || creator.gameUUID() != "" {
+		return nil, errOpponentAway
+	}
+	if sameAccount(creator, u) {
+		return nil, errOwnInvite
+	}
+	game, err := createGame(u.repository, creator, u, invite.variant)
+	if err != nil {
+		return nil, err
+	}
+	u.repository.RemoveInvite(invite)
+	return game, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// leaderboard periods, days and weeks are UTC calendar ones, weeks start
+// on Monday
+const (
+	PeriodDaily  = "daily"
+	PeriodWeekly = "weekly"
+	PeriodAll    = "all"
+)
+
+// leaderboards rank accounts by their last rating or by the wins within
+// the period
+const (
+	RankByRating = "rating"
+	RankByWins   = "wins"
+)
+
+// the default and the maximum LeaderboardGet page
+const (
+	leaderboardPage    = 20
+	maxLeaderboardPage = 100
+)
+
+// GameResult is the outcome of a decided game for an account, rating is
+// the rating of the account after it
+type GameResult struct {
+	accountID  string
+	outcome    string
+	rating     int
+	finishedAt time.Time
+}
+
+// LeaderboardEntry is a ranked account, equal values share the rank
+type LeaderboardEntry struct {
+	rank      int
+	accountID string
+	value     int
+}
+
+// periodStart returns when the current period started, false for an
+// unknown period
+func periodStart(period string, now time.Time) (time.Time, bool) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodDaily:
+		return day, true
+	case PeriodWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), true
+	case PeriodAll:
+		return time.Time{}, true
+	}
+	return time.Time{}, false
+}
+
+// Standing sums the results of an account within a period: the wins and
+// the last of them, the rating after the last result and when it was
+type Standing struct {
+	accountID string
+	wins      int
+	wonAt     time.Time
+	rating    int
+	ratedAt   time.Time
+}
+
+// add counts the result in, results of equal time count in the order added
+func (s *Standing) add(result *GameResult) {
+	if result.outcome == outcomeWin {
+		s.wins++
+		if result.finishedAt.After(s.wonAt) {
+			s.wonAt = result.finishedAt
+		}
+	}
+	if !result.finishedAt.Before(s.ratedAt) {
+		s.rating, s.ratedAt = result.rating, result.finishedAt
+	}
+}
+
+// merge counts in the standing of the account for a later part of the period
+func (s *Standing) merge(other *Standing) {
+	s.wins += other.wins
+	if other.wonAt.After(s.wonAt) {
+		s.wonAt = other.wonAt
+	}
+	if !other.ratedAt.Before(s.ratedAt) {
+		s.rating, s.ratedAt = other.rating, other.ratedAt
+	}
+}
+
+// rankStandings ranks the accounts of standings. Among equal values the
+// account which reached its value first is listed first.
+func rankStandings(standings []*Standing, by string) []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(standings))
+	reachedAt := map[string]time.Time{}
+	for _, standing := range standings {
+		switch by {
+		case RankByRating:
+			entries = append(entries, LeaderboardEntry{0, standing.accountID, standing.rating})
+			reachedAt[standing.accountID] = standing.ratedAt
+		case RankByWins:
+			if standing.wins == 0 {
+				continue
+			}
+			entries = append(entries, LeaderboardEntry{0, standing.accountID, standing.wins})
+			reachedAt[standing.accountID] = standing.wonAt
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].value != entries[j].value {
+			return entries[i].value > entries[j].value
+		}
+		first, second := reachedAt[entries[i].accountID], reachedAt[entries[j].accountID]
+		if !first.Equal(second) {
+			return first.Before(second)
+		}
+		return entries[i].accountID < entries[j].accountID
+	})
+	for i := range entries {
+		entries[i].rank = i + 1
+		if i > 0 && entries[i].value == entries[i-1].value {
+			entries[i].rank = entries[i-1].rank
+		}
+	}
+	return entries
+}
+
+// leaderboard answers LeaderboardGet with a page of the board and the
+// place of the user on it
+func (u *User) leaderboard(request Message) error {
+	period, by := PeriodAll, RankByRating
+	if value, ok := request.Payload["period"]; ok {
+		period = value
+	}
+	if value, ok := request.Payload["by"]; ok {
+		by = value
+	}
+	since, ok := periodStart(period, time.Now())
+	if !ok || (by != RankByRating && by != RankByWins) {
+		return errInvalidPayload
+	}
+	offset, limit := 0, leaderboardPage
+	var err error
+	if value, ok := request.Payload["offset"]; ok {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return errInvalidPayload
+		}
+	}
+	if value, ok := request.Payload["limit"]; ok {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxLeaderboardPage {
+			return errInvalidPayload
+		}
+	}
+
+	entries := rankStandings(u.repository.Standings(since), by)
+	payload := map[string]string{
+		"period": period,
+		"by":     by,
+		"total":  strconv.Itoa(len(entries)),
+	}
+	page := []map[string]string{}
+	for i := offset; i < len(entries) && i < offset+limit; i++ {
+		entry := entries[i]
+		username := ""
+		if account := u.repository.AccountByID(entry.accountID); account != nil {
+			username = account.username
+		}
+		page = append(page, map[string]string{
+			"rank":      strconv.Itoa(entry.rank),
+			"accountID": entry.accountID,
+			"username":  username,
+			"value":     strconv.Itoa(entry.value),
+		})
+	}
+	encoded, _ := json.Marshal(page)
+	payload["entries"] = string(encoded)
+	if accountID := u.account(); accountID != "" {
+		for _, entry := range entries {
+			if entry.accountID == accountID {
+				payload["myRank"] = strconv.Itoa(entry.rank)
+				payload["myValue"] = strconv.Itoa(entry.value)
+				break
+			}
+		}
+	}
+	u.send(Message{
+		ID:      request.ID,
+		Type:    LeaderboardResult,
+		Payload: payload,
+	})
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	friday := time.Date(2017, 7, 14, 15, 0, 0, 0, time.UTC)
+	sunday := time.Date(2017, 7, 16, 23, 59, 0, 0, time.FixedZone("MSK", 3*60*60))
+	tests := []struct {
+		period string
+		now    time.Time
+		want   time.Time
+	}{
+		{PeriodDaily, friday, time.Date(2017, 7, 14, 0, 0, 0, 0, time.UTC)},
+		{PeriodWeekly, friday, time.Date(2017, 7, 10, 0, 0, 0, 0, time.UTC)},
+		{PeriodDaily, sunday, time.Date(2017, 7, 16, 0, 0, 0, 0, time.UTC)},
+		{PeriodWeekly, sunday, time.Date(2017, 7, 10, 0, 0, 0, 0, time.UTC)},
+		{PeriodWeekly, time.Date(2017, 7, 10, 0, 0, 0, 0, time.UTC), time.Date(2017, 7, 10, 0, 0, 0, 0, time.UTC)},
+		{PeriodAll, friday, time.Time{}},
+	}
+	for _, tt := range tests {
+		got, ok := periodStart(tt.period, tt.now)
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("periodStart(%v, %v) = %v, want %v", tt.period, tt.now, got, tt.want)
+		}
+	}
+	if _, ok := periodStart("monthly", friday); ok {
+		t.Errorf("periodStart() accepts unknown periods")
+	}
+}
+
+func TestRankStandings(t *testing.T) {
+	at := func(minutes int) time.Time {
+		return time.Unix(1500000000, 0).Add(time.Duration(minutes) * time.Minute)
+	}
+	results := []*GameResult{
+		{"alice", outcomeWin, 1216, at(1)},
+		{"bob", outcomeLoss, 1184, at(1)},
+		{"carol", outcomeWin, 1216, at(2)},
+		{"alice", outcomeDraw, 1210, at(3)},
+		{"bob", outcomeWin, 1200, at(4)},
+	}
+	standings := map[string]*Standing{}
+	for _, result := range results {
+		addStanding(standings, result)
+	}
+	tests := []struct {
+		by   string
+		want []LeaderboardEntry
+	}{
+		{RankByWins, []LeaderboardEntry{{1, "alice", 1}, {1, "carol", 1}, {1, "bob", 1}}},
+		{RankByRating, []LeaderboardEntry{{1, "carol", 1216}, {2, "alice", 1210}, {3, "bob", 1200}}},
+	}
+	for _, tt := range tests {
+		list := []*Standing{standings["alice"], standings["bob"], standings["carol"]}
+		if got := rankStandings(list, tt.by); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rankStandings(%v) = %v, want %v", tt.by, got, tt.want)
+		}
+	}
+}
+
+func TestGameRepository_Standings(t *testing.T) {
+	repository := InmemoryRepository().(*GameRepository)
+	monday := time.Date(2017, 7, 10, 0, 0, 0, 0, time.UTC)
+	for day := -10; day < 7; day++ {
+		repository.AddGameResult(&GameResult{"alice", outcomeWin, 1200 + day, monday.AddDate(0, 0, day).Add(time.Hour)})
+	}
+	repository.AddGameResult(&GameResult{"bob", outcomeLoss, 1184, monday.AddDate(0, 0, 3)})
+	if len(repository.dayStandings) != standingDays {
+		t.Errorf("days kept = %v, want %v", len(repository.dayStandings), standingDays)
+	}
+
+	week := map[string]Standing{}
+	for _, standing := range repository.Standings(monday) {
+		week[standing.accountID] = *standing
+	}
+	want := map[string]Standing{
+		"alice": {"alice", 7, monday.AddDate(0, 0, 6).Add(time.Hour), 1206, monday.AddDate(0, 0, 6).Add(time.Hour)},
+		"bob":   {"bob", 0, time.Time{}, 1184, monday.AddDate(0, 0, 3)},
+	}
+	if !reflect.DeepEqual(week, want) {
+		t.Errorf("weekly standings = %v, want %v", week, want)
+	}
+	all := map[string]*Standing{}
+	for _, standing := range repository.Standings(time.Time{}) {
+		all[standing.accountID] = standing
+	}
+	if len(all) != 2 || all["alice"].wins != 17 || all["alice"].rating != 1206 {
+		t.Errorf("all standings = %v", all)
+	}
+}
+
+func TestUser_Leaderboard(t *testing.T) {
+	repository := InmemoryRepository()
+	now := time.Now()
+	for i, name := range []string{"alice", "bob", "carol"} {
+		repository.SaveAccount(&Account{name, name, defaultRating, "", now, time.Time{}, nil})
+		for wins := 0; wins <= i; wins++ {
+			repository.AddGameResult(&GameResult{name, outcomeWin, defaultRating, now})
+		}
+	}
+	// last month alice was the best
+	for wins := 0; wins < 5; wins++ {
+		repository.AddGameResult(&GameResult{"alice", outcomeWin, defaultRating, now.AddDate(0, -1, 0)})
+	}
+	user := MockUserWithRepository(repository)
+	user.accountID = "alice"
+
+	user.resolveMessage(Message{ID: "board", Type: LeaderboardGet, Payload: map[string]string{
+		"period": PeriodWeekly,
+		"by":     RankByWins,
+		"offset": "1",
+		"limit":  "1",
+	}})
+	gotMsg := <-user.writeChan
+	var entries []map[string]string
+	json.Unmarshal([]byte(gotMsg.Payload["entries"]), &entries)
+	want := []map[string]string{{"rank": "2", "accountID": "bob", "username": "bob", "value": "2"}}
+	if gotMsg.ID != "board" || gotMsg.Type != LeaderboardResult || !reflect.DeepEqual(entries, want) ||
+		gotMsg.Payload["total"] != "3" || gotMsg.Payload["myRank"] != "3" || gotMsg.Payload["myValue"] != "1" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+
+	user.resolveMessage(Message{Type: LeaderboardGet, Payload: map[string]string{"by": RankByWins}})
+	if gotMsg := <-user.writeChan; gotMsg.Payload["myRank"] != "1" || gotMsg.Payload["myValue"] != "6" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	user.resolveMessage(Message{Type: LeaderboardGet, Payload: map[string]string{"period": "monthly"}})
+	if gotMsg := <-user.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "INVALID_PAYLOAD" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -20,7 +22,44 @@ type IRepository interface {
 	AddUserInSearch(user *User)
 	RemoveUserInSearch(user *User)
 	AddGame(game *Game)
+	// RemoveGame drops the chat history of the game room as well
 	RemoveGame(game *Game)
+	// SaveUser and SaveGame persist changes made to already added objects
+	SaveUser(user *User)
+	SaveGame(game *Game)
+	// replays outlive the games removed by gameCleaner
+	SaveReplay(replay *Replay)
+	ReplayByUUID(gameUUID string) *Replay
+	InviteByCode(code string) *Invite
+	Invites() map[string]*Invite
+	AddInvite(invite *Invite)
+	RemoveInvite(invite *Invite)
+	// challenges live as long as the players are online, they aren't persisted
+	ChallengeByID(id string) *Challenge
+	Challenges() map[string]*Challenge
+	AddChallenge(challenge *Challenge)
+	RemoveChallenge(challenge *Challenge)
+	// AddChatEntry assigns the seq of entry, ChatHistory returns up to
+	// limit entries of channel older than before (any when before is 0),
+	// oldest first
+	AddChatEntry(entry *ChatEntry)
+	ChatHistory(channel string, before int64, limit int) []*ChatEntry
+	// accounts outlive the users logged into them
+	AccountByID(id string) *Account
+	// AccountByUsername ignores the case of username
+	AccountByUsername(username string) *Account
+	SaveAccount(account *Account)
+	// StatsByAccount returns nil for accounts without decided games
+	StatsByAccount(accountID string) *PlayerStats
+	SaveStats(stats *PlayerStats)
+	// Standings sums the results finished since the time per account, the
+	// time is the start of a UTC day or zero for all the results
+	AddGameResult(result *GameResult)
+	Standings(since time.Time) []*Standing
+	// Friendships returns the friends and the requests of both directions
+	Friendships(accountID string) []*Friendship
+	SaveFriendship(friendship *Friendship)
+	RemoveFriendship(friendship *Friendship)
 }
 
 var wsUpgrader = websocket.Upgrader{
@@ -29,26 +68,38 @@ var wsUpgrader = websocket.Upgrader{
 	},
 }
 
-var Repository IRepository
-
-func init() {
-	Repository = InmemoryRepository()
-}
+// Repository is replaced by the one of the config in main
+var Repository = InmemoryRepository()
 
 func main() {
+	config, err := LoadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		// the usage is printed already
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+	Repository, err = config.Apply()
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+	log.Println("using", config.Repository, "repository")
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tickerGSC := time.Tick(15 * time.Second)
-	tickerCleaner := time.Tick(15 * time.Second)
+	tickerGSC := time.Tick(config.MatchInterval)
+	tickerCleaner := time.Tick(config.CleanInterval)
 
 	go gameSessionsCreator(Repository, ctx, tickerGSC)
 	go gameCleaner(Repository, ctx, tickerCleaner)
 
 	http.HandleFunc("/", handleWebsocketConnections)
+	http.HandleFunc("/profile", handleProfile)
 
-	log.Println("http server started on :17666")
-	err := http.ListenAndServe(":17666", nil)
+	log.Println("http server started on", config.Listen)
+	err = http.ListenAndServe(config.Listen, nil)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
@@ -56,18 +107,22 @@ func main() {
 
 func handleWebsocketConnections(w http.ResponseWriter, r *http.Request) {
 	log.Println("New connection")
+	var claims *Claims
+	if token := requestToken(r); token != "" {
+		var err error
+		claims, err = ParseToken(authSecret, token, time.Now())
+		if err != nil {
+			http.Error(w, "invalid auth token", http.StatusUnauthorized)
+			return
+		}
+	}
+	// Upgrade has answered the client with an error already
 	ws, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
-	}
-	user := &User{
-		generateUUID(),
-		"<empty>",
-		"",
-		ws,
-		make(chan Message, 2),
-		Repository,
+		log.Printf("error: %v", err)
+		return
 	}
-	go user.readLoop()
-	go user.writeLoop()
+	user := NewUser(Repository)
+	user.claims = claims
+	user.attach(ws)
 }
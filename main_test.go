@@ -2,20 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
 
 func MockUserWithRepository(repository IRepository) *User {
-	return &User{
-		generateUUID(),
-		generateUUID(),
-		"",
-		nil,
-		make(chan Message, 2),
-		repository,
-	}
+	user := NewUser(repository)
+	user.username = generateUUID()
+	return user
 }
 
 func Test_Chat(t *testing.T) {
@@ -24,12 +24,13 @@ func Test_Chat(t *testing.T) {
 	mockUserFirst := MockUserWithRepository(repository)
 	mockUserSecond := MockUserWithRepository(repository)
 
-	mockUserFirst.resolveMessage(Message{Login, map[string]string{"username": "1"}})
+	mockUserFirst.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
 	expectMsg := Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserFirst.uuid,
-			"username": "1",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserFirst.uuid,
+			"username":       "user1",
+			"reconnectToken": mockUserFirst.reconnectToken,
 		},
 	}
 	gotMsg := <-mockUserFirst.writeChan
@@ -37,12 +38,13 @@ func Test_Chat(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{Login, map[string]string{"username": "2"}})
+	mockUserSecond.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
 	expectMsg = Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserSecond.uuid,
-			"username": "2",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserSecond.uuid,
+			"username":       "user2",
+			"reconnectToken": mockUserSecond.reconnectToken,
 		},
 	}
 	gotMsg = <-mockUserSecond.writeChan
@@ -50,29 +52,78 @@ func Test_Chat(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{MessageSend, map[string]string{"text": "Hi! It is gopher!"}})
+	mockUserFirst.resolveMessage(Message{Type: ChatJoin, Payload: map[string]string{"room": "gophers"}})
+	mockUserSecond.resolveMessage(Message{Type: ChatJoin, Payload: map[string]string{"room": "gophers"}})
 	expectMsg = Message{
-		MessageNew,
-		map[string]string{
-			"text": "Hi! It is gopher!",
+		Type: ChatJoined,
+		Payload: map[string]string{
+			"room":     "gophers",
+			"userUUID": mockUserSecond.uuid,
+			"username": "user2",
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+
+	mockUserFirst.resolveMessage(Message{Type: MessageSend, Payload: map[string]string{"room": "gophers", "text": "Hi! It is gopher!"}})
+	expectMsg = Message{
+		Type: MessageNew,
+		Payload: map[string]string{
+			"seq":      "1",
+			"text":     "Hi! It is gopher!",
+			"username": "user1",
+			"userUUID": mockUserFirst.uuid,
+			"room":     "gophers",
 		},
 	}
 	gotMsg = <-mockUserSecond.writeChan
+	expectMsg.Payload["sentAt"] = gotMsg.Payload["sentAt"]
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+
+	mockUserSecond.resolveMessage(Message{Type: MessageSend, Payload: map[string]string{"to": mockUserFirst.uuid, "text": "Hey! It is Elephant!"}})
+	expectMsg = Message{
+		Type: MessageNew,
+		Payload: map[string]string{
+			"seq":      "2",
+			"text":     "Hey! It is Elephant!",
+			"username": "user2",
+			"userUUID": mockUserSecond.uuid,
+			"to":       mockUserFirst.uuid,
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	expectMsg.Payload["sentAt"] = gotMsg.Payload["sentAt"]
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{MessageSend, map[string]string{"text": "Hey! It is Elephant!"}})
+	// there is no global chat anymore
+	mockUserSecond.resolveMessage(Message{Type: MessageSend, Payload: map[string]string{"text": "Anybody?"}})
+	if gotMsg = <-mockUserSecond.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "NO_ACTIVE_GAME" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+
+	mockUserSecond.resolveMessage(Message{Type: ChatLeave, Payload: map[string]string{"room": "gophers"}})
 	expectMsg = Message{
-		MessageNew,
-		map[string]string{
-			"text": "Hey! It is Elephant!",
+		Type: ChatLeft,
+		Payload: map[string]string{
+			"room":     "gophers",
+			"userUUID": mockUserSecond.uuid,
+			"username": "user2",
 		},
 	}
 	gotMsg = <-mockUserFirst.writeChan
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
+	mockUserSecond.resolveMessage(Message{Type: MessageSend, Payload: map[string]string{"room": "gophers", "text": "Bye"}})
+	if gotMsg = <-mockUserSecond.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "NOT_IN_ROOM" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
 }
 
 // when someone leave the game
@@ -82,12 +133,13 @@ func TestGame_GameOver(t *testing.T) {
 	mockUserFirst := MockUserWithRepository(repository)
 	mockUserSecond := MockUserWithRepository(repository)
 
-	mockUserFirst.resolveMessage(Message{Login, map[string]string{"username": "1"}})
+	mockUserFirst.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
 	expectMsg := Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserFirst.uuid,
-			"username": "1",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserFirst.uuid,
+			"username":       "user1",
+			"reconnectToken": mockUserFirst.reconnectToken,
 		},
 	}
 	gotMsg := <-mockUserFirst.writeChan
@@ -95,12 +147,13 @@ func TestGame_GameOver(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{Login, map[string]string{"username": "2"}})
+	mockUserSecond.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
 	expectMsg = Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserSecond.uuid,
-			"username": "2",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserSecond.uuid,
+			"username":       "user2",
+			"reconnectToken": mockUserSecond.reconnectToken,
 		},
 	}
 	gotMsg = <-mockUserSecond.writeChan
@@ -108,8 +161,30 @@ func TestGame_GameOver(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameSearchOn, map[string]string{}})
-	mockUserSecond.resolveMessage(Message{GameSearchOn, map[string]string{}})
+	mockUserFirst.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expectMsg = Message{
+		Type: GameSearchWait,
+		Payload: map[string]string{
+			"expectedWait": "-1",
+			"rating":       "1200",
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+	mockUserSecond.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expectMsg = Message{
+		Type: GameSearchWait,
+		Payload: map[string]string{
+			"expectedWait": "0",
+			"rating":       "1200",
+		},
+	}
+	gotMsg = <-mockUserSecond.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
@@ -118,8 +193,8 @@ func TestGame_GameOver(t *testing.T) {
 	cancel()
 
 	expectMsg = Message{
-		GameSearchOff,
-		map[string]string{},
+		Type:    GameSearchOff,
+		Payload: map[string]string{},
 	}
 	gotMsg = <-mockUserFirst.writeChan
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
@@ -131,11 +206,15 @@ func TestGame_GameOver(t *testing.T) {
 	}
 
 	expectMsg = Message{
-		GameSearchStart,
-		map[string]string{
+		Type: GameSearchStart,
+		Payload: map[string]string{
 			"gameUUID":      mockUserFirst.currentGameUUID,
 			"crossUserUUID": mockUserFirst.uuid,
 			"zeroUserUUID":  mockUserSecond.uuid,
+			"game":          "tictactoe",
+			"width":         "3",
+			"height":        "3",
+			"winLength":     "3",
 		},
 	}
 	gotMsg = <-mockUserFirst.writeChan
@@ -147,10 +226,10 @@ func TestGame_GameOver(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameOver, map[string]string{}})
+	mockUserFirst.resolveMessage(Message{Type: GameOver, Payload: map[string]string{}})
 	expectMsg = Message{
-		GameOver,
-		map[string]string{},
+		Type:    GameOver,
+		Payload: map[string]string{},
 	}
 	gotMsg = <-mockUserSecond.writeChan
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
@@ -164,12 +243,13 @@ func TestGame_WithWinner(t *testing.T) {
 	mockUserFirst := MockUserWithRepository(repository)
 	mockUserSecond := MockUserWithRepository(repository)
 
-	mockUserFirst.resolveMessage(Message{Login, map[string]string{"username": "1"}})
+	mockUserFirst.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
 	expectMsg := Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserFirst.uuid,
-			"username": "1",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserFirst.uuid,
+			"username":       "user1",
+			"reconnectToken": mockUserFirst.reconnectToken,
 		},
 	}
 	gotMsg := <-mockUserFirst.writeChan
@@ -177,12 +257,13 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{Login, map[string]string{"username": "2"}})
+	mockUserSecond.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
 	expectMsg = Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserSecond.uuid,
-			"username": "2",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserSecond.uuid,
+			"username":       "user2",
+			"reconnectToken": mockUserSecond.reconnectToken,
 		},
 	}
 	gotMsg = <-mockUserSecond.writeChan
@@ -190,8 +271,30 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameSearchOn, map[string]string{}})
-	mockUserSecond.resolveMessage(Message{GameSearchOn, map[string]string{}})
+	mockUserFirst.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expectMsg = Message{
+		Type: GameSearchWait,
+		Payload: map[string]string{
+			"expectedWait": "-1",
+			"rating":       "1200",
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+	mockUserSecond.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expectMsg = Message{
+		Type: GameSearchWait,
+		Payload: map[string]string{
+			"expectedWait": "0",
+			"rating":       "1200",
+		},
+	}
+	gotMsg = <-mockUserSecond.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
@@ -200,8 +303,8 @@ func TestGame_WithWinner(t *testing.T) {
 	cancel()
 
 	expectMsg = Message{
-		GameSearchOff,
-		map[string]string{},
+		Type:    GameSearchOff,
+		Payload: map[string]string{},
 	}
 	gotMsg = <-mockUserFirst.writeChan
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
@@ -213,11 +316,15 @@ func TestGame_WithWinner(t *testing.T) {
 	}
 
 	expectMsg = Message{
-		GameSearchStart,
-		map[string]string{
+		Type: GameSearchStart,
+		Payload: map[string]string{
 			"gameUUID":      mockUserFirst.currentGameUUID,
 			"crossUserUUID": mockUserFirst.uuid,
 			"zeroUserUUID":  mockUserSecond.uuid,
+			"game":          "tictactoe",
+			"width":         "3",
+			"height":        "3",
+			"winLength":     "3",
 		},
 	}
 	gotMsg = <-mockUserFirst.writeChan
@@ -229,14 +336,10 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "1"}})
-	// check duplicate
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "1"}})
-	// check empty position
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"failedparam": "failedvalue"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "1"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "CROSS",
 			"2": "EMPTY",
 			"3": "EMPTY",
@@ -257,10 +360,40 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{GameMove, map[string]string{"position": "7"}})
+	// check duplicate
+	mockUserFirst.resolveMessage(Message{ID: "dup", Type: GameMove, Payload: map[string]string{"position": "1"}})
+	expectMsg = Message{
+		ID:   "dup",
+		Type: Error,
+		Payload: map[string]string{
+			"code":        "NOT_YOUR_TURN",
+			"message":     "not your turn",
+			"requestType": GameMove,
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+	// check empty position
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"failedparam": "failedvalue"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: Error,
+		Payload: map[string]string{
+			"code":        "INVALID_POSITION",
+			"message":     "invalid position",
+			"requestType": GameMove,
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+
+	mockUserSecond.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "7"}})
+	expectMsg = Message{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "CROSS",
 			"2": "EMPTY",
 			"3": "EMPTY",
@@ -281,10 +414,10 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "2"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "2"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "CROSS",
 			"2": "CROSS",
 			"3": "EMPTY",
@@ -305,10 +438,10 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{GameMove, map[string]string{"position": "8"}})
+	mockUserSecond.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "8"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "CROSS",
 			"2": "CROSS",
 			"3": "EMPTY",
@@ -329,10 +462,10 @@ func TestGame_WithWinner(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "3"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "3"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "CROSS",
 			"2": "CROSS",
 			"3": "CROSS",
@@ -354,8 +487,8 @@ func TestGame_WithWinner(t *testing.T) {
 	}
 
 	expectMsg = Message{
-		GameWinner,
-		map[string]string{
+		Type: GameWinner,
+		Payload: map[string]string{
 			"winner": "CROSS",
 		},
 	}
@@ -367,6 +500,10 @@ func TestGame_WithWinner(t *testing.T) {
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
+
+	if mockUserFirst.rating != 1216 || mockUserSecond.rating != 1184 {
+		t.Errorf("ratings weren't updated: %v %v", mockUserFirst.rating, mockUserSecond.rating)
+	}
 }
 
 func TestGame_Draw(t *testing.T) {
@@ -375,12 +512,13 @@ func TestGame_Draw(t *testing.T) {
 	mockUserFirst := MockUserWithRepository(repository)
 	mockUserSecond := MockUserWithRepository(repository)
 
-	mockUserFirst.resolveMessage(Message{Login, map[string]string{"username": "1"}})
+	mockUserFirst.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
 	expectMsg := Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserFirst.uuid,
-			"username": "1",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserFirst.uuid,
+			"username":       "user1",
+			"reconnectToken": mockUserFirst.reconnectToken,
 		},
 	}
 	gotMsg := <-mockUserFirst.writeChan
@@ -388,12 +526,13 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{Login, map[string]string{"username": "2"}})
+	mockUserSecond.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
 	expectMsg = Message{
-		LoginSuccess,
-		map[string]string{
-			"uuid":     mockUserSecond.uuid,
-			"username": "2",
+		Type: LoginSuccess,
+		Payload: map[string]string{
+			"uuid":           mockUserSecond.uuid,
+			"username":       "user2",
+			"reconnectToken": mockUserSecond.reconnectToken,
 		},
 	}
 	gotMsg = <-mockUserSecond.writeChan
@@ -401,8 +540,30 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameSearchOn, map[string]string{}})
-	mockUserSecond.resolveMessage(Message{GameSearchOn, map[string]string{}})
+	mockUserFirst.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expectMsg = Message{
+		Type: GameSearchWait,
+		Payload: map[string]string{
+			"expectedWait": "-1",
+			"rating":       "1200",
+		},
+	}
+	gotMsg = <-mockUserFirst.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+	mockUserSecond.resolveMessage(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	expectMsg = Message{
+		Type: GameSearchWait,
+		Payload: map[string]string{
+			"expectedWait": "0",
+			"rating":       "1200",
+		},
+	}
+	gotMsg = <-mockUserSecond.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go gameSessionsCreator(repository, ctx, time.Tick(1*time.Nanosecond))
@@ -411,8 +572,8 @@ func TestGame_Draw(t *testing.T) {
 	cancel()
 
 	expectMsg = Message{
-		GameSearchOff,
-		map[string]string{},
+		Type:    GameSearchOff,
+		Payload: map[string]string{},
 	}
 	gotMsg = <-mockUserFirst.writeChan
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
@@ -424,11 +585,15 @@ func TestGame_Draw(t *testing.T) {
 	}
 
 	expectMsg = Message{
-		GameSearchStart,
-		map[string]string{
+		Type: GameSearchStart,
+		Payload: map[string]string{
 			"gameUUID":      mockUserFirst.currentGameUUID,
 			"crossUserUUID": mockUserFirst.uuid,
 			"zeroUserUUID":  mockUserSecond.uuid,
+			"game":          "tictactoe",
+			"width":         "3",
+			"height":        "3",
+			"winLength":     "3",
 		},
 	}
 	gotMsg = <-mockUserFirst.writeChan
@@ -440,10 +605,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "5"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "5"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "EMPTY",
 			"2": "EMPTY",
 			"3": "EMPTY",
@@ -464,10 +629,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{GameMove, map[string]string{"position": "3"}})
+	mockUserSecond.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "3"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "EMPTY",
 			"2": "EMPTY",
 			"3": "ZERO",
@@ -488,10 +653,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "9"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "9"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "EMPTY",
 			"2": "EMPTY",
 			"3": "ZERO",
@@ -512,10 +677,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{GameMove, map[string]string{"position": "1"}})
+	mockUserSecond.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "1"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "ZERO",
 			"2": "EMPTY",
 			"3": "ZERO",
@@ -536,10 +701,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "2"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "2"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "ZERO",
 			"2": "CROSS",
 			"3": "ZERO",
@@ -560,10 +725,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{GameMove, map[string]string{"position": "8"}})
+	mockUserSecond.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "8"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "ZERO",
 			"2": "CROSS",
 			"3": "ZERO",
@@ -584,10 +749,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "4"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "4"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "ZERO",
 			"2": "CROSS",
 			"3": "ZERO",
@@ -608,10 +773,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserSecond.resolveMessage(Message{GameMove, map[string]string{"position": "6"}})
+	mockUserSecond.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "6"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "ZERO",
 			"2": "CROSS",
 			"3": "ZERO",
@@ -632,10 +797,10 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 
-	mockUserFirst.resolveMessage(Message{GameMove, map[string]string{"position": "7"}})
+	mockUserFirst.resolveMessage(Message{Type: GameMove, Payload: map[string]string{"position": "7"}})
 	expectMsg = Message{
-		GameMoved,
-		map[string]string{
+		Type: GameMoved,
+		Payload: map[string]string{
 			"1": "ZERO",
 			"2": "CROSS",
 			"3": "ZERO",
@@ -657,8 +822,8 @@ func TestGame_Draw(t *testing.T) {
 	}
 
 	expectMsg = Message{
-		GameDraw,
-		map[string]string{},
+		Type:    GameDraw,
+		Payload: map[string]string{},
 	}
 	gotMsg = <-mockUserFirst.writeChan
 	if !reflect.DeepEqual(expectMsg, gotMsg) {
@@ -669,3 +834,634 @@ func TestGame_Draw(t *testing.T) {
 		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
 	}
 }
+
+func dialTestServer(t *testing.T, server *httptest.Server) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+// readUntil skips messages until one of messageType arrives
+func readUntil(t *testing.T, ws *websocket.Conn, messageType string) Message {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var message Message
+		err := ws.ReadJSON(&message)
+		if err != nil {
+			t.Fatalf("waiting for %v: %v", messageType, err)
+		}
+		if message.Type == messageType {
+			return message
+		}
+	}
+}
+
+func startTestGame(t *testing.T) (*httptest.Server, *websocket.Conn, *websocket.Conn, func()) {
+	Repository = InmemoryRepository()
+	server := httptest.NewServer(http.HandlerFunc(handleWebsocketConnections))
+	ctx, cancel := context.WithCancel(context.Background())
+	go gameSessionsCreator(Repository, ctx, time.Tick(10*time.Millisecond))
+
+	first := dialTestServer(t, server)
+	first.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
+	readUntil(t, first, LoginSuccess)
+	first.WriteJSON(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	readUntil(t, first, GameSearchWait)
+
+	second := dialTestServer(t, server)
+	second.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
+	readUntil(t, second, LoginSuccess)
+	second.WriteJSON(Message{Type: GameSearchOn, Payload: map[string]string{}})
+
+	readUntil(t, first, GameSearchStart)
+	readUntil(t, second, GameSearchStart)
+	return server, first, second, func() {
+		cancel()
+		server.Close()
+	}
+}
+
+func TestUser_SendTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { writeTimeout = timeout }(writeTimeout)
+	writeTimeout = 50 * time.Millisecond
+	user := MockUser()
+
+	sent := make(chan struct{})
+	go func() {
+		user.send(Message{Type: GameSearchWait})
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send waits for a client that doesn't read")
+	}
+
+	go user.send(Message{Type: GameSearchOff})
+	if gotMsg := <-user.writeChan; gotMsg.Type != GameSearchOff {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
+
+func TestUser_Resume(t *testing.T) {
+	server, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var token string
+	for _, user := range Repository.Users() {
+		if user.username == "user1" {
+			token = user.reconnectToken
+		}
+	}
+
+	first.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": "5"}})
+	readUntil(t, second, GameMoved)
+	first.Close()
+
+	// the game goes on while the first player is away
+	time.Sleep(100 * time.Millisecond)
+	second.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": "1"}})
+	readUntil(t, second, GameMoved)
+
+	resumed := dialTestServer(t, server)
+	defer resumed.Close()
+	resumed.WriteJSON(Message{Type: Resume, Payload: map[string]string{"token": token}})
+	readUntil(t, resumed, ResumeSuccess)
+	gotMsg := readUntil(t, resumed, GameResumed)
+	if gotMsg.Payload["currentMoveUnit"] != string(CROSS) {
+		t.Errorf("invalid current move unit after resume: %v", gotMsg)
+	}
+	gotMsg = readUntil(t, resumed, GameMoved)
+	if gotMsg.Payload["5"] != string(CROSS) || gotMsg.Payload["1"] != string(ZERO) {
+		t.Errorf("invalid field after resume: %v", gotMsg)
+	}
+
+	resumed.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": "9"}})
+	gotMsg = readUntil(t, second, GameMoved)
+	if gotMsg.Payload["9"] != string(CROSS) {
+		t.Errorf("move after resume wasn't made: %v", gotMsg)
+	}
+}
+
+func TestUser_ResumeExpired(t *testing.T) {
+	_, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var game *Game
+	for _, game = range Repository.GameSessions() {
+	}
+	first.Close()
+	// expire right away instead of waiting for the grace period
+	deadline := time.Now().Add(2 * time.Second)
+	for game.crossUser.connection() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("user wasn't detached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	game.crossUser.expire()
+	readUntil(t, second, GameOver)
+}
+
+func TestUser_Login(t *testing.T) {
+	repository := InmemoryRepository()
+	first, second := MockUserWithRepository(repository), MockUserWithRepository(repository)
+
+	first.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "gopher"}})
+	if gotMsg := <-first.writeChan; gotMsg.Type != LoginSuccess {
+		t.Fatalf("invalid write message %v", gotMsg)
+	}
+	for username, reason := range map[string]string{
+		"":                      "USERNAME_TOO_SHORT",
+		"go":                    "USERNAME_TOO_SHORT",
+		strings.Repeat("g", 21): "USERNAME_TOO_LONG",
+		"go pher":               "USERNAME_INVALID",
+		"<script>":              "USERNAME_INVALID",
+		"Admin":                 "USERNAME_RESERVED",
+		"GOPHER":                "USERNAME_TAKEN",
+	} {
+		second.resolveMessage(Message{ID: "login", Type: Login, Payload: map[string]string{"username": username}})
+		gotMsg := <-second.writeChan
+		if gotMsg.ID != "login" || gotMsg.Type != LoginFailed || gotMsg.Payload["reason"] != reason {
+			t.Errorf("login as %q: got %v, want %v", username, gotMsg, reason)
+		}
+	}
+	if repository.UserByUUID(second.uuid) != nil {
+		t.Errorf("rejected user was added")
+	}
+	second.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "гофер"}})
+	if gotMsg := <-second.writeChan; gotMsg.Type != LoginSuccess {
+		t.Fatalf("invalid write message %v", gotMsg)
+	}
+
+	// Login doesn't rename, Rename does
+	first.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "gopher2"}})
+	if gotMsg := <-first.writeChan; gotMsg.Type != LoginFailed || gotMsg.Payload["reason"] != "ALREADY_LOGGED_IN" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	first.resolveMessage(Message{Type: Rename, Payload: map[string]string{"username": "Гофер"}})
+	if gotMsg := <-first.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "USERNAME_TAKEN" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	first.resolveMessage(Message{ID: "rename", Type: Rename, Payload: map[string]string{"username": "Gopher"}})
+	if gotMsg := <-first.writeChan; gotMsg.ID != "rename" || gotMsg.Type != RenameSuccess || gotMsg.Payload["username"] != "Gopher" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	if first.name() != "Gopher" {
+		t.Errorf("username = %v, want Gopher", first.name())
+	}
+}
+
+func TestUser_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		code    string
+	}{
+		{
+			"unknown type",
+			Message{ID: "1", Type: "Unknown", Payload: map[string]string{}},
+			"UNKNOWN_TYPE",
+		},
+		{
+			"move without a game",
+			Message{ID: "2", Type: GameMove, Payload: map[string]string{"position": "1"}},
+			"NO_ACTIVE_GAME",
+		},
+		{
+			"unsupported variant",
+			Message{ID: "3", Type: GameSearchOn, Payload: map[string]string{"game": "chess"}},
+			"INVALID_VARIANT",
+		},
+		{
+			"invalid reconnect token",
+			Message{ID: "4", Type: Resume, Payload: map[string]string{"token": "invalid"}},
+			"INVALID_TOKEN",
+		},
+		{
+			"chat without text",
+			Message{ID: "5", Type: MessageSend, Payload: map[string]string{}},
+			"INVALID_PAYLOAD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUser := MockUserWithRepository(InmemoryRepository())
+			mockUser.resolveMessage(tt.message)
+			gotMsg := <-mockUser.writeChan
+			if gotMsg.ID != tt.message.ID || gotMsg.Type != Error || gotMsg.Payload["code"] != tt.code ||
+				gotMsg.Payload["requestType"] != tt.message.Type {
+				t.Errorf("invalid error message for %v code\n got %v", tt.code, gotMsg)
+			}
+		})
+	}
+}
+
+func TestUser_RequestIDs(t *testing.T) {
+	mockUser := MockUserWithRepository(InmemoryRepository())
+
+	mockUser.resolveMessage(Message{ID: "login", Type: Login, Payload: map[string]string{"username": "user1"}})
+	gotMsg := <-mockUser.writeChan
+	if gotMsg.ID != "login" || gotMsg.Type != LoginSuccess {
+		t.Errorf("invalid write message\n expect: LoginSuccess with login id\n got %v", gotMsg)
+	}
+
+	mockUser.resolveMessage(Message{ID: "search", Type: GameSearchOn, Payload: map[string]string{}})
+	gotMsg = <-mockUser.writeChan
+	if gotMsg.ID != "search" || gotMsg.Type != GameSearchWait {
+		t.Errorf("invalid write message\n expect: GameSearchWait with search id\n got %v", gotMsg)
+	}
+
+	mockUser.resolveMessage(Message{ID: "off", Type: GameSearchOff, Payload: map[string]string{}})
+	expectMsg := Message{
+		ID:      "off",
+		Type:    Ack,
+		Payload: map[string]string{"requestType": GameSearchOff},
+	}
+	gotMsg = <-mockUser.writeChan
+	if !reflect.DeepEqual(expectMsg, gotMsg) {
+		t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+	}
+
+	// messages without an ID aren't acknowledged
+	mockUser.resolveMessage(Message{Type: GameSearchOff, Payload: map[string]string{}})
+	select {
+	case gotMsg = <-mockUser.writeChan:
+		t.Errorf("unexpected write message %v", gotMsg)
+	default:
+	}
+}
+
+func TestGame_Replay(t *testing.T) {
+	_, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var game *Game
+	for _, game = range Repository.GameSessions() {
+	}
+	first.WriteJSON(Message{Type: GameReplay, Payload: map[string]string{"gameUUID": game.uuid}})
+	gotMsg := readUntil(t, first, Error)
+	if gotMsg.Payload["code"] != "REPLAY_NOT_FOUND" {
+		t.Errorf("replay of a running game: %v", gotMsg)
+	}
+
+	for i, position := range []string{"1", "4", "2", "5", "3"} {
+		player := first
+		if i%2 == 1 {
+			player = second
+		}
+		player.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": position}})
+		readUntil(t, first, GameMoved)
+		readUntil(t, second, GameMoved)
+	}
+	readUntil(t, first, GameWinner)
+	readUntil(t, second, GameWinner)
+
+	second.WriteJSON(Message{ID: "replay", Type: GameReplay, Payload: map[string]string{"gameUUID": game.uuid}})
+	gotMsg = readUntil(t, second, GameReplayResult)
+	if gotMsg.ID != "replay" || gotMsg.Payload["gameUUID"] != game.uuid || gotMsg.Payload["result"] != "1-0" {
+		t.Errorf("invalid replay: %v", gotMsg)
+	}
+	var moves []map[string]string
+	err := json.Unmarshal([]byte(gotMsg.Payload["moves"]), &moves)
+	if err != nil || len(moves) != 5 {
+		t.Fatalf("invalid replay moves %v: %v", gotMsg.Payload["moves"], err)
+	}
+	if moves[1]["unit"] != "ZERO" || moves[1]["userUUID"] != game.zeroUser.uuid || moves[1]["position"] != "4" {
+		t.Errorf("invalid second move: %v", moves[1])
+	}
+	notation := strings.Fields(gotMsg.Payload["notation"])
+	if len(notation) != 11 || notation[0] != "tictactoe" || notation[1] != "3x3x3" || notation[5] != "1-0" ||
+		!strings.HasPrefix(notation[10], "3@") {
+		t.Errorf("invalid notation: %v", gotMsg.Payload["notation"])
+	}
+}
+
+func TestGame_Resign(t *testing.T) {
+	_, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var game *Game
+	for _, game = range Repository.GameSessions() {
+	}
+	first.WriteJSON(Message{Type: GameResign, Payload: map[string]string{}})
+	expectMsg := Message{
+		Type: GameWinner,
+		Payload: map[string]string{
+			"winner": "ZERO",
+			"reason": "resign",
+		},
+	}
+	for _, ws := range []*websocket.Conn{first, second} {
+		gotMsg := readUntil(t, ws, GameWinner)
+		if !reflect.DeepEqual(expectMsg, gotMsg) {
+			t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+		}
+	}
+	if Repository.UserByUUID(game.crossUser.uuid) == nil {
+		t.Errorf("resigned user shouldn't leave")
+	}
+}
+
+func TestGame_Draw_Agreement(t *testing.T) {
+	_, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	second.WriteJSON(Message{Type: DrawAccept, Payload: map[string]string{}})
+	if gotMsg := readUntil(t, second, Error); gotMsg.Payload["code"] != "NO_DRAW_OFFER" {
+		t.Errorf("accepted a draw nobody offered: %v", gotMsg)
+	}
+
+	first.WriteJSON(Message{Type: DrawOffer, Payload: map[string]string{}})
+	readUntil(t, second, DrawOffer)
+	second.WriteJSON(Message{Type: DrawDecline, Payload: map[string]string{}})
+	readUntil(t, first, DrawDecline)
+
+	first.WriteJSON(Message{Type: DrawOffer, Payload: map[string]string{}})
+	readUntil(t, second, DrawOffer)
+	second.WriteJSON(Message{Type: DrawAccept, Payload: map[string]string{}})
+	expectMsg := Message{
+		Type:    GameDraw,
+		Payload: map[string]string{"reason": "agreement"},
+	}
+	for _, ws := range []*websocket.Conn{first, second} {
+		gotMsg := readUntil(t, ws, GameDraw)
+		if !reflect.DeepEqual(expectMsg, gotMsg) {
+			t.Errorf("invalid write message\n expect: %v\n got %v", expectMsg, gotMsg)
+		}
+	}
+}
+
+func TestGame_Rematch(t *testing.T) {
+	_, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var game *Game
+	for _, game = range Repository.GameSessions() {
+	}
+	second.WriteJSON(Message{Type: RematchAccept, Payload: map[string]string{}})
+	if gotMsg := readUntil(t, second, Error); gotMsg.Payload["code"] != "ALREADY_IN_GAME" {
+		t.Errorf("rematch of a running game: %v", gotMsg)
+	}
+
+	first.WriteJSON(Message{Type: GameResign, Payload: map[string]string{}})
+	readUntil(t, first, GameWinner)
+	readUntil(t, second, GameWinner)
+
+	second.WriteJSON(Message{Type: RematchAccept, Payload: map[string]string{}})
+	if gotMsg := readUntil(t, second, Error); gotMsg.Payload["code"] != "NO_REMATCH" {
+		t.Errorf("accepted a rematch nobody asked for: %v", gotMsg)
+	}
+
+	first.WriteJSON(Message{Type: RematchRequest, Payload: map[string]string{}})
+	gotMsg := readUntil(t, second, RematchRequest)
+	if gotMsg.Payload["userUUID"] != game.crossUser.uuid || gotMsg.Payload["gameUUID"] != game.uuid {
+		t.Errorf("invalid rematch request: %v", gotMsg)
+	}
+	second.WriteJSON(Message{Type: RematchAccept, Payload: map[string]string{}})
+	for _, ws := range []*websocket.Conn{first, second} {
+		gotMsg = readUntil(t, ws, GameSearchStart)
+		if gotMsg.Payload["gameUUID"] == game.uuid ||
+			gotMsg.Payload["crossUserUUID"] != game.zeroUser.uuid || gotMsg.Payload["zeroUserUUID"] != game.crossUser.uuid {
+			t.Errorf("colors weren't swapped in the rematch: %v", gotMsg)
+		}
+	}
+}
+
+func TestGame_Private(t *testing.T) {
+	Repository = InmemoryRepository()
+	server := httptest.NewServer(http.HandlerFunc(handleWebsocketConnections))
+	defer server.Close()
+
+	first := dialTestServer(t, server)
+	first.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
+	firstUUID := readUntil(t, first, LoginSuccess).Payload["uuid"]
+	first.WriteJSON(Message{ID: "create", Type: GameCreatePrivate, Payload: map[string]string{"game": "connectfour"}})
+	gotMsg := readUntil(t, first, GamePrivateCreated)
+	code := gotMsg.Payload["code"]
+	if gotMsg.ID != "create" || len(code) != inviteCodeLength || gotMsg.Payload["expiresAt"] == "" {
+		t.Fatalf("invalid invite: %v", gotMsg)
+	}
+	first.WriteJSON(Message{Type: GameJoinPrivate, Payload: map[string]string{"code": code}})
+	if gotMsg = readUntil(t, first, Error); gotMsg.Payload["code"] != "OWN_INVITE" {
+		t.Errorf("joined own invite: %v", gotMsg)
+	}
+
+	second := dialTestServer(t, server)
+	second.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
+	secondUUID := readUntil(t, second, LoginSuccess).Payload["uuid"]
+	second.WriteJSON(Message{Type: GameJoinPrivate, Payload: map[string]string{"code": "XXXXXX"}})
+	if gotMsg = readUntil(t, second, Error); gotMsg.Payload["code"] != "INVITE_NOT_FOUND" {
+		t.Errorf("joined unknown invite: %v", gotMsg)
+	}
+
+	// codes are case insensitive
+	second.WriteJSON(Message{Type: GameJoinPrivate, Payload: map[string]string{"code": strings.ToLower(code)}})
+	for _, ws := range []*websocket.Conn{first, second} {
+		gotMsg = readUntil(t, ws, GameSearchStart)
+		if gotMsg.Payload["crossUserUUID"] != firstUUID || gotMsg.Payload["zeroUserUUID"] != secondUUID ||
+			gotMsg.Payload["game"] != ConnectFour {
+			t.Errorf("invalid private game: %v", gotMsg)
+		}
+	}
+	if Repository.InviteByCode(code) != nil {
+		t.Errorf("invite should be used once")
+	}
+}
+
+func TestGame_Challenge(t *testing.T) {
+	Repository = InmemoryRepository()
+	server := httptest.NewServer(http.HandlerFunc(handleWebsocketConnections))
+	defer server.Close()
+
+	first := dialTestServer(t, server)
+	first.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user1"}})
+	firstUUID := readUntil(t, first, LoginSuccess).Payload["uuid"]
+	second := dialTestServer(t, server)
+	second.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user2"}})
+	secondUUID := readUntil(t, second, LoginSuccess).Payload["uuid"]
+	second.WriteJSON(Message{Type: GameSearchOn, Payload: map[string]string{}})
+	readUntil(t, second, GameSearchWait)
+
+	first.WriteJSON(Message{Type: ChallengeSend, Payload: map[string]string{"username": "USER1"}})
+	if gotMsg := readUntil(t, first, Error); gotMsg.Payload["code"] != "OWN_CHALLENGE" {
+		t.Errorf("challenged yourself: %v", gotMsg)
+	}
+	first.WriteJSON(Message{Type: ChallengeSend, Payload: map[string]string{"userUUID": "unknown"}})
+	if gotMsg := readUntil(t, first, Error); gotMsg.Payload["code"] != "USER_NOT_FOUND" {
+		t.Errorf("challenged an unknown user: %v", gotMsg)
+	}
+
+	// declined challenges are gone
+	first.WriteJSON(Message{ID: "send", Type: ChallengeSend, Payload: map[string]string{"username": "user2"}})
+	sent := readUntil(t, first, ChallengeSent)
+	received := readUntil(t, second, ChallengeReceived)
+	if sent.ID != "send" || sent.Payload["userUUID"] != secondUUID || received.Payload["challengeID"] != sent.Payload["challengeID"] ||
+		received.Payload["userUUID"] != firstUUID || received.Payload["game"] != TicTacToe {
+		t.Fatalf("invalid challenge: %v %v", sent, received)
+	}
+	second.WriteJSON(Message{Type: ChallengeDecline, Payload: map[string]string{"challengeID": received.Payload["challengeID"]}})
+	if gotMsg := readUntil(t, first, ChallengeDeclined); gotMsg.Payload["challengeID"] != sent.Payload["challengeID"] {
+		t.Errorf("invalid decline: %v", gotMsg)
+	}
+	second.WriteJSON(Message{Type: ChallengeAccept, Payload: map[string]string{"challengeID": received.Payload["challengeID"]}})
+	if gotMsg := readUntil(t, second, Error); gotMsg.Payload["code"] != "CHALLENGE_NOT_FOUND" {
+		t.Errorf("accepted a declined challenge: %v", gotMsg)
+	}
+
+	first.WriteJSON(Message{Type: ChallengeSend, Payload: map[string]string{"userUUID": secondUUID, "game": "connectfour"}})
+	received = readUntil(t, second, ChallengeReceived)
+	first.WriteJSON(Message{Type: ChallengeAccept, Payload: map[string]string{"challengeID": received.Payload["challengeID"]}})
+	if gotMsg := readUntil(t, first, Error); gotMsg.Payload["code"] != "CHALLENGE_NOT_FOUND" {
+		t.Errorf("accepted own challenge: %v", gotMsg)
+	}
+	second.WriteJSON(Message{Type: ChallengeAccept, Payload: map[string]string{"challengeID": received.Payload["challengeID"]}})
+	for _, ws := range []*websocket.Conn{first, second} {
+		gotMsg := readUntil(t, ws, GameSearchStart)
+		if gotMsg.Payload["crossUserUUID"] != firstUUID || gotMsg.Payload["zeroUserUUID"] != secondUUID ||
+			gotMsg.Payload["game"] != ConnectFour {
+			t.Errorf("invalid challenge game: %v", gotMsg)
+		}
+	}
+	if len(Repository.UsersInSearch()) != 0 || len(Repository.Challenges()) != 0 {
+		t.Errorf("players are still searching or challenged")
+	}
+}
+
+func TestUser_CloseChallenges(t *testing.T) {
+	repository := InmemoryRepository()
+	leaving, challenger, challenged := MockUserWithRepository(repository), MockUserWithRepository(repository), MockUserWithRepository(repository)
+	for _, user := range []*User{leaving, challenger, challenged} {
+		repository.AddUser(user)
+	}
+	received := NewChallenge(challenger, leaving, DefaultGameVariant, time.Now())
+	sent := NewChallenge(leaving, challenged, DefaultGameVariant, time.Now())
+	repository.AddChallenge(received)
+	repository.AddChallenge(sent)
+
+	leaving.close()
+	if gotMsg := <-challenger.writeChan; gotMsg.Type != ChallengeDeclined || gotMsg.Payload["challengeID"] != received.id {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	if gotMsg := <-challenged.writeChan; gotMsg.Type != ChallengeCancelled || gotMsg.Payload["challengeID"] != sent.id {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	if len(repository.Challenges()) != 0 {
+		t.Errorf("challenges of a closed user = %v", repository.Challenges())
+	}
+}
+
+func TestGame_Watch(t *testing.T) {
+	server, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var game *Game
+	for _, game = range Repository.GameSessions() {
+	}
+	first.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": "5"}})
+	readUntil(t, first, GameMoved)
+	readUntil(t, second, GameMoved)
+
+	spectator := dialTestServer(t, server)
+	spectator.WriteJSON(Message{Type: GameWatch, Payload: map[string]string{"gameUUID": game.uuid}})
+	if gotMsg := readUntil(t, spectator, Error); gotMsg.Payload["code"] != "NOT_LOGGED_IN" {
+		t.Errorf("anonymous user watches the game: %v", gotMsg)
+	}
+	spectator.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user3"}})
+	readUntil(t, spectator, LoginSuccess)
+
+	spectator.WriteJSON(Message{Type: GameList, Payload: map[string]string{}})
+	var games []map[string]string
+	json.Unmarshal([]byte(readUntil(t, spectator, GameListResult).Payload["games"]), &games)
+	if len(games) != 1 || games[0]["gameUUID"] != game.uuid || games[0]["moves"] != "1" || games[0]["crossUsername"] != "user1" {
+		t.Errorf("invalid live games: %v", games)
+	}
+
+	spectator.WriteJSON(Message{ID: "watch", Type: GameWatch, Payload: map[string]string{"gameUUID": game.uuid}})
+	gotMsg := readUntil(t, spectator, GameWatchStarted)
+	if gotMsg.ID != "watch" || gotMsg.Payload["gameUUID"] != game.uuid || gotMsg.Payload["currentMoveUnit"] != "ZERO" ||
+		gotMsg.Payload["spectators"] != "1" {
+		t.Errorf("invalid watch state: %v", gotMsg)
+	}
+	if gotMsg = readUntil(t, spectator, GameMoved); gotMsg.Payload["5"] != "CROSS" {
+		t.Errorf("invalid watch field: %v", gotMsg)
+	}
+	for _, ws := range []*websocket.Conn{first, second} {
+		if gotMsg = readUntil(t, ws, GameSpectators); gotMsg.Payload["spectators"] != "1" {
+			t.Errorf("invalid spectators count: %v", gotMsg)
+		}
+	}
+
+	spectator.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": "1"}})
+	if gotMsg = readUntil(t, spectator, Error); gotMsg.Payload["code"] != "NO_ACTIVE_GAME" {
+		t.Errorf("spectator moved: %v", gotMsg)
+	}
+
+	second.WriteJSON(Message{Type: GameMove, Payload: map[string]string{"position": "1"}})
+	if gotMsg = readUntil(t, spectator, GameMoved); gotMsg.Payload["1"] != "ZERO" {
+		t.Errorf("spectator didn't see the move: %v", gotMsg)
+	}
+	first.WriteJSON(Message{Type: GameResign, Payload: map[string]string{}})
+	if gotMsg = readUntil(t, spectator, GameWinner); gotMsg.Payload["winner"] != "ZERO" {
+		t.Errorf("spectator didn't see the result: %v", gotMsg)
+	}
+}
+
+func TestGame_Chat(t *testing.T) {
+	server, first, second, cleanup := startTestGame(t)
+	defer cleanup()
+
+	var game *Game
+	for _, game = range Repository.GameSessions() {
+	}
+	spectator := dialTestServer(t, server)
+	spectator.WriteJSON(Message{Type: Login, Payload: map[string]string{"username": "user3"}})
+	readUntil(t, spectator, LoginSuccess)
+	spectator.WriteJSON(Message{Type: GameWatch, Payload: map[string]string{"gameUUID": game.uuid}})
+	readUntil(t, spectator, GameWatchStarted)
+
+	first.WriteJSON(Message{Type: MessageSend, Payload: map[string]string{"text": "gl hf"}})
+	for _, ws := range []*websocket.Conn{second, spectator} {
+		gotMsg := readUntil(t, ws, MessageNew)
+		if gotMsg.Payload["text"] != "gl hf" || gotMsg.Payload["room"] != GameRoom || gotMsg.Payload["gameUUID"] != game.uuid {
+			t.Errorf("invalid game chat message: %v", gotMsg)
+		}
+	}
+	spectator.WriteJSON(Message{Type: MessageSend, Payload: map[string]string{"room": "game", "text": "go cross"}})
+	for _, ws := range []*websocket.Conn{first, second} {
+		if gotMsg := readUntil(t, ws, MessageNew); gotMsg.Payload["text"] != "go cross" {
+			t.Errorf("invalid game chat message: %v", gotMsg)
+		}
+	}
+	// the history pages back from the newest message
+	var texts []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		payload := map[string]string{"limit": "1"}
+		if cursor != "" {
+			payload["before"] = cursor
+		}
+		second.WriteJSON(Message{ID: "h", Type: ChatHistory, Payload: payload})
+		gotMsg := readUntil(t, second, ChatHistoryResult)
+		var messages []map[string]string
+		if err := json.Unmarshal([]byte(gotMsg.Payload["messages"]), &messages); err != nil || gotMsg.ID != "h" {
+			t.Fatalf("invalid history: %v", gotMsg)
+		}
+		for _, message := range messages {
+			texts = append(texts, message["text"])
+		}
+		cursor = gotMsg.Payload["nextCursor"]
+		if cursor == "" {
+			break
+		}
+	}
+	if !reflect.DeepEqual(texts, []string{"go cross", "gl hf"}) {
+		t.Errorf("history = %v, want newest first pages of the game chat", texts)
+	}
+	second.WriteJSON(Message{Type: ChatHistory, Payload: map[string]string{"before": "x"}})
+	if gotMsg := readUntil(t, second, Error); gotMsg.Payload["code"] != "INVALID_CURSOR" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
@@ -1,24 +1,248 @@
 package main
 
+import "fmt"
+
 const (
 	Login        = "Login"
 	LoginSuccess = "LoginSuccess"
+	// LoginFailed carries the reason code of a rejected username
+	LoginFailed   = "LoginFailed"
+	Rename        = "Rename"
+	RenameSuccess = "RenameSuccess"
+	// Register creates an account with a password, a guest keeps its game
+	Register        = "Register"
+	RegisterSuccess = "RegisterSuccess"
+	// ProfileGet returns the stats of an account, the own one by default
+	ProfileGet    = "ProfileGet"
+	ProfileResult = "ProfileResult"
+	// LeaderboardGet returns a page of a leaderboard, see leaderboard.go
+	LeaderboardGet    = "LeaderboardGet"
+	LeaderboardResult = "LeaderboardResult"
+	// friends of an account, FriendRequested, FriendAccepted, FriendRemoved
+	// and Presence are pushed to the other side
+	FriendRequest    = "FriendRequest"
+	FriendAccept     = "FriendAccept"
+	FriendDecline    = "FriendDecline"
+	FriendRemove     = "FriendRemove"
+	FriendList       = "FriendList"
+	FriendListResult = "FriendListResult"
+	FriendRequested  = "FriendRequested"
+	FriendAccepted   = "FriendAccepted"
+	FriendRemoved    = "FriendRemoved"
+	Presence         = "Presence"
+
+	Resume        = "Resume"
+	ResumeSuccess = "ResumeSuccess"
+	GameResumed   = "GameResumed"
 
 	GameSearchOn    = "GameSearchOn"
 	GameSearchOff   = "GameSearchOff"
 	GameSearchStart = "GameSearchStart"
+	// GameSearchWait reports the expected wait in seconds, -1 when unknown
+	GameSearchWait = "GameSearchWait"
 
 	GameOver   = "GameOver"
 	GameMove   = "GameMove"
 	GameMoved  = "GameMoved"
 	GameWinner = "GameWinner"
 	GameDraw   = "GameDraw"
+	// GameClock follows GameMoved in games with a time control, it holds
+	// the time left in milliseconds
+	GameClock = "GameClock"
+
+	// the commands below are forwarded to the opponent as they are
+	GameResign     = "GameResign"
+	DrawOffer      = "DrawOffer"
+	DrawAccept     = "DrawAccept"
+	DrawDecline    = "DrawDecline"
+	RematchRequest = "RematchRequest"
+	RematchAccept  = "RematchAccept"
+
+	// GameCreatePrivate is answered with the code of the invite,
+	// GameJoinPrivate starts the game of the code
+	GameCreatePrivate  = "GameCreatePrivate"
+	GamePrivateCreated = "GamePrivateCreated"
+	GameJoinPrivate    = "GameJoinPrivate"
+
+	// ChallengeSend offers a game to an online user, who receives
+	// ChallengeReceived and starts the game with ChallengeAccept.
+	// ChallengeDeclined, ChallengeCancelled and ChallengeExpired tell the
+	// other side that the challenge is gone.
+	ChallengeSend      = "ChallengeSend"
+	ChallengeSent      = "ChallengeSent"
+	ChallengeReceived  = "ChallengeReceived"
+	ChallengeAccept    = "ChallengeAccept"
+	ChallengeDecline   = "ChallengeDecline"
+	ChallengeDeclined  = "ChallengeDeclined"
+	ChallengeCancelled = "ChallengeCancelled"
+	ChallengeExpired   = "ChallengeExpired"
+
+	// GameWatch makes the user a spectator of a running game, GameList
+	// lists the running games to watch
+	GameWatch        = "GameWatch"
+	GameWatchStarted = "GameWatchStarted"
+	GameUnwatch      = "GameUnwatch"
+	GameSpectators   = "GameSpectators"
+	GameList         = "GameList"
+	GameListResult   = "GameListResult"
 
+	// GameReplay asks for the history of a finished game
+	GameReplay       = "GameReplay"
+	GameReplayResult = "GameReplayResult"
+
+	// MessageSend goes to a user, a chat room or the game room
 	MessageSend = "MessageSend"
 	MessageNew  = "MessageNew"
+	ChatJoin    = "ChatJoin"
+	ChatJoined  = "ChatJoined"
+	ChatLeave   = "ChatLeave"
+	ChatLeft    = "ChatLeft"
+	// ChatHistory pages back through the messages of a channel
+	ChatHistory       = "ChatHistory"
+	ChatHistoryResult = "ChatHistoryResult"
+	// ChatBlock stops the delivery of the messages of a user, ChatMute is
+	// an admin request forbidding a user to chat for a while. Both hold for
+	// every session of an account, the guests are named by their session.
+	ChatBlock           = "ChatBlock"
+	ChatUnblock         = "ChatUnblock"
+	ChatBlockList       = "ChatBlockList"
+	ChatBlockListResult = "ChatBlockListResult"
+	ChatMute            = "ChatMute"
+	ChatMuted           = "ChatMuted"
+	ChatUnmuted         = "ChatUnmuted"
+
+	// Error is sent whenever a client message is rejected
+	Error = "Error"
+	// Ack confirms a message that has no response of its own
+	Ack = "Ack"
 )
 
 type Message struct {
+	// ID is an optional client chosen request ID, echoed on the response
+	ID      string            `json:"id,omitempty"`
 	Type    string            `json:"type"`
 	Payload map[string]string `json:"payload"`
 }
+
+// secretPayloadKeys are the credentials messages carry, they never reach
+// the log
+var secretPayloadKeys = []string{"password", "token", "reconnectToken", "key"}
+
+// String describes the message for the log with the secrets masked
+func (m Message) String() string {
+	payload := make(map[string]string, len(m.Payload))
+	for key, value := range m.Payload {
+		payload[key] = value
+	}
+	for _, key := range secretPayloadKeys {
+		if _, ok := payload[key]; ok {
+			payload[key] = "***"
+		}
+	}
+	return fmt.Sprintf("{%v %v %v}", m.ID, m.Type, payload)
+}
+
+// acknowledged lists the messages confirmed with Ack when they carry an ID,
+// the others are answered directly
+var acknowledged = map[string]bool{
+	GameSearchOff:    true,
+	GameOver:         true,
+	GameMove:         true,
+	GameResign:       true,
+	DrawOffer:        true,
+	DrawAccept:       true,
+	DrawDecline:      true,
+	RematchRequest:   true,
+	RematchAccept:    true,
+	GameJoinPrivate:  true,
+	ChallengeAccept:  true,
+	ChallengeDecline: true,
+	GameUnwatch:      true,
+	MessageSend:      true,
+	ChatJoin:         true,
+	ChatLeave:        true,
+	ChatBlock:        true,
+	ChatUnblock:      true,
+	ChatMute:         true,
+	FriendRequest:    true,
+	FriendAccept:     true,
+	FriendDecline:    true,
+	FriendRemove:     true,
+}
+
+// ProtocolError is a rejection reported to the client in an Error message
+type ProtocolError struct {
+	Code string
+	Text string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Text
+}
+
+var (
+	errUnknownType        = &ProtocolError{"UNKNOWN_TYPE", "unknown message type"}
+	errInvalidPayload     = &ProtocolError{"INVALID_PAYLOAD", "required payload fields are missing"}
+	errInvalidVariant     = &ProtocolError{"INVALID_VARIANT", "unsupported game variant"}
+	errInvalidToken       = &ProtocolError{"INVALID_TOKEN", "token is invalid or expired"}
+	errNoActiveGame       = &ProtocolError{"NO_ACTIVE_GAME", "there is no active game"}
+	errGameIsOver         = &ProtocolError{"GAME_IS_OVER", "game is over"}
+	errNotYourTurn        = &ProtocolError{"NOT_YOUR_TURN", "not your turn"}
+	errInvalidPosition    = &ProtocolError{"INVALID_POSITION", "invalid position"}
+	errCellOccupied       = &ProtocolError{"CELL_OCCUPIED", "cell is occupied"}
+	errColumnFull         = &ProtocolError{"COLUMN_FULL", "column is full"}
+	errReplayNotFound     = &ProtocolError{"REPLAY_NOT_FOUND", "there is no finished game with this uuid"}
+	errNoDrawOffer        = &ProtocolError{"NO_DRAW_OFFER", "there is no draw offer to answer"}
+	errNoRematch          = &ProtocolError{"NO_REMATCH", "there is no rematch to play"}
+	errAlreadyInGame      = &ProtocolError{"ALREADY_IN_GAME", "finish the current game first"}
+	errOpponentAway       = &ProtocolError{"OPPONENT_AWAY", "the opponent is offline or playing another game"}
+	errInviteNotFound     = &ProtocolError{"INVITE_NOT_FOUND", "invite code is unknown or expired"}
+	errOwnInvite          = &ProtocolError{"OWN_INVITE", "cannot join your own private game"}
+	errChallengeNotFound  = &ProtocolError{"CHALLENGE_NOT_FOUND", "challenge is unknown or expired"}
+	errOwnChallenge       = &ProtocolError{"OWN_CHALLENGE", "cannot challenge yourself"}
+	errSameAccount        = &ProtocolError{"SAME_ACCOUNT", "cannot play against your own account"}
+	errNotLoggedIn        = &ProtocolError{"NOT_LOGGED_IN", "log in first"}
+	errGameNotFound       = &ProtocolError{"GAME_NOT_FOUND", "there is no running game with this uuid"}
+	errUserNotFound       = &ProtocolError{"USER_NOT_FOUND", "there is no online user with this uuid"}
+	errInvalidRoom        = &ProtocolError{"INVALID_ROOM", "room names are 1-32 letters, digits, - or _"}
+	errTooManyRooms       = &ProtocolError{"TOO_MANY_ROOMS", "leave a room before joining another one"}
+	errNotInRoom          = &ProtocolError{"NOT_IN_ROOM", "join the room first"}
+	errInvalidCursor      = &ProtocolError{"INVALID_CURSOR", "invalid history cursor"}
+	errMessageTooLong     = &ProtocolError{"MESSAGE_TOO_LONG", "the message is too long"}
+	errRateLimited        = &ProtocolError{"RATE_LIMITED", "too many messages, slow down"}
+	errMuted              = &ProtocolError{"MUTED", "you are muted"}
+	errForbidden          = &ProtocolError{"FORBIDDEN", "not allowed"}
+	errUsernameTooShort   = &ProtocolError{"USERNAME_TOO_SHORT", "the username is too short"}
+	errUsernameTooLong    = &ProtocolError{"USERNAME_TOO_LONG", "the username is too long"}
+	errUsernameInvalid    = &ProtocolError{"USERNAME_INVALID", "use letters, digits, dots, dashes and underscores"}
+	errUsernameReserved   = &ProtocolError{"USERNAME_RESERVED", "the username is reserved"}
+	errUsernameTaken      = &ProtocolError{"USERNAME_TAKEN", "the username is taken"}
+	errAlreadyLoggedIn    = &ProtocolError{"ALREADY_LOGGED_IN", "already logged in, use Rename"}
+	errInvalidAuthToken   = &ProtocolError{"INVALID_AUTH_TOKEN", "invalid or expired auth token"}
+	errInvalidCredentials = &ProtocolError{"INVALID_CREDENTIALS", "invalid username or password"}
+	errInvalidPassword    = &ProtocolError{"INVALID_PASSWORD", "the password must be 8 to 72 bytes long"}
+	errAlreadyRegistered  = &ProtocolError{"ALREADY_REGISTERED", "the user already has an account"}
+	errAccountNotFound    = &ProtocolError{"ACCOUNT_NOT_FOUND", "account not found"}
+	errAccountRequired    = &ProtocolError{"ACCOUNT_REQUIRED", "log in with an account first"}
+	errAlreadyFriends     = &ProtocolError{"ALREADY_FRIENDS", "you are friends already"}
+	errNotFriends         = &ProtocolError{"NOT_FRIENDS", "you aren't friends"}
+	errNoFriendRequest    = &ProtocolError{"NO_FRIEND_REQUEST", "there is no such friend request"}
+	errTooManyFriends     = &ProtocolError{"TOO_MANY_FRIENDS", "too many friends"}
+)
+
+// errorMessage builds the Error reply to request
+func errorMessage(request Message, err error) Message {
+	protocolError, ok := err.(*ProtocolError)
+	if !ok {
+		protocolError = &ProtocolError{"INTERNAL_ERROR", err.Error()}
+	}
+	return Message{
+		ID:   request.ID,
+		Type: Error,
+		Payload: map[string]string{
+			"code":        protocolError.Code,
+			"message":     protocolError.Text,
+			"requestType": request.Type,
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxChatMessageLength is the longest MessageSend text in characters
+const maxChatMessageLength = 500
+
+// a user sends at most chatRateLimit messages per chatRateWindow
+var (
+	chatRateLimit  = 5
+	chatRateWindow = 10 * time.Second
+)
+
+// maxChatMute bounds the duration of ChatMute
+const maxChatMute = 30 * 24 * time.Hour
+
+// chatFilter masks the words of the CHAT_WORD_LIST file, nil disables it
+var chatFilter *WordFilter
+
+// adminKey authorizes moderation requests, they are disabled when empty
+var adminKey string
+
+// WordFilter masks words of a list in chat messages. Words are compared
+// case-insensitively and only as whole words, "class" isn't masked for "ass".
+type WordFilter struct {
+	words map[string]bool
+}
+
+func NewWordFilter(words []string) *WordFilter {
+	filter := &WordFilter{map[string]bool{}}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			filter.words[word] = true
+		}
+	}
+	return filter
+}
+
+// LoadWordFilter reads a word list with a word per line, lines starting
+// with # are comments
+func LoadWordFilter(path string) (*WordFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewWordFilter(words), nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Mask replaces every letter of the listed words in text with an asterisk
+func (f *WordFilter) Mask(text string) string {
+	var masked strings.Builder
+	for len(text) > 0 {
+		end := strings.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+		if end == 0 {
+			_, size := utf8.DecodeRuneInString(text)
+			masked.WriteString(text[:size])
+			text = text[size:]
+			continue
+		}
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		if f.words[strings.ToLower(word)] {
+			word = strings.Repeat("*", utf8.RuneCountInString(word))
+		}
+		masked.WriteString(word)
+		text = text[end:]
+	}
+	return masked.String()
+}
+
+// allowChat records a message sent at now, false when the user already
+// sent chatRateLimit messages within chatRateWindow
+func (u *User) allowChat(now time.Time) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	recent := u.chatSentAt[:0]
+	for _, sentAt := range u.chatSentAt {
+		if now.Sub(sentAt) < chatRateWindow {
+			recent = append(recent, sentAt)
+		}
+	}
+	u.chatSentAt = recent
+	if len(recent) >= chatRateLimit {
+		return false
+	}
+	u.chatSentAt = append(u.chatSentAt, now)
+	return true
+}
+
+// blocks tells whether the user blocked the sender
+func (u *User) blocks(sender *User) bool {
+	return u.blocksIdentity(sender.uuid, sender.account())
+}
+
+// blocksIdentity is blocks for the user userUUID of the account, which
+// may be offline
+func (u *User) blocksIdentity(userUUID, accountID string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.blocked[userUUID] || (accountID != "" && u.blocked[accountID])
+}
+
+// blockedUsers returns the identities the user blocked, sorted
+func (u *User) blockedUsers() []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	users := make([]string, 0, len(u.blocked))
+	for userUUID := range u.blocked {
+		users = append(users, userUUID)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// setBlocked blocks or unblocks the messages of userUUID. The block holds
+// the identity of the user, so the sessions of an account share it; an
+// offline user is named by the identity ChatBlockList returned.
+func (u *User) setBlocked(userUUID string, blocked bool) error {
+	identity := userUUID
+	if user := u.repository.UserByUUID(userUUID); user != nil {
+		identity = user.identity()
+	}
+	if identity == "" || identity == u.uuid || identity == u.account() {
+		return errUserNotFound
+	}
+	u.mutex.Lock()
+	if blocked {
+		u.blocked[identity] = true
+	} else {
+		delete(u.blocked, identity)
+	}
+	u.mutex.Unlock()
+	u.repository.SaveUser(u)
+	u.saveAccount()
+	return nil
+}
+
+// mutedTill returns when the mute of the user ends, zero when it isn't muted
+func (u *User) mutedTill(now time.Time) time.Time {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if !u.mutedUntil.After(now) {
+		return time.Time{}
+	}
+	return u.mutedUntil
+}
+
+// mute forbids userUUID to chat for the duration, zero lifts the mute.
+// The mute of a user with an account holds for every session of the
+// account. The request is authorized by the admin key.
+func (u *User) mute(key, userUUID string, duration time.Duration) error {
+	if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+		return errForbidden
+	}
+	if duration < 0 || duration > maxChatMute {
+		return errInvalidPayload
+	}
+	user := u.repository.UserByUUID(userUUID)
+	if user == nil {
+		return errUserNotFound
+	}
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	muted := []*User{user}
+	if accountID := user.account(); accountID != "" {
+		for _, other := range u.repository.Users() {
+			if other != user && other.account() == accountID {
+				muted = append(muted, other)
+			}
+		}
+	}
+	message := Message{Type: ChatUnmuted, Payload: map[string]string{}}
+	if !until.IsZero() {
+		message = Message{
+			Type:    ChatMuted,
+			Payload: map[string]string{"until": until.UTC().Format(time.RFC3339)},
+		}
+	}
+	for _, user := range muted {
+		user.mutex.Lock()
+		user.mutedUntil = until
+		user.mutex.Unlock()
+		user.repository.SaveUser(user)
+	}
+	user.saveAccount()
+	for _, user := range muted {
+		user.send(message)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWordFilter_Mask(t *testing.T) {
+	filter := NewWordFilter([]string{"darn", " Heck", "блин", ""})
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"darn it", "**** it"},
+		{"DARN, heck!", "****, ****!"},
+		{"darnation is fine", "darnation is fine"},
+		{"ну блин", "ну ****"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := filter.Mask(tt.text); got != tt.want {
+			t.Errorf("WordFilter.Mask(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestUser_ChatModeration(t *testing.T) {
+	repository := InmemoryRepository()
+	sender, receiver := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	for i, user := range []*User{sender, receiver} {
+		user.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": []string{"sender", "receiver"}[i]}})
+		<-user.writeChan
+		user.resolveMessage(Message{Type: ChatJoin, Payload: map[string]string{"room": "gophers"}})
+	}
+	<-sender.writeChan
+	expectError := func(user *User, code string) {
+		t.Helper()
+		if gotMsg := <-user.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != code {
+			t.Errorf("got %v, want error %v", gotMsg, code)
+		}
+	}
+	say := func(user *User, text string) {
+		user.resolveMessage(Message{Type: MessageSend, Payload: map[string]string{"room": "gophers", "text": text}})
+	}
+
+	say(sender, strings.Repeat("a", maxChatMessageLength+1))
+	expectError(sender, "MESSAGE_TOO_LONG")
+
+	receiver.resolveMessage(Message{Type: ChatBlock, Payload: map[string]string{"userUUID": sender.uuid}})
+	say(sender, "blocked")
+	receiver.resolveMessage(Message{Type: ChatBlockList})
+	if gotMsg := <-receiver.writeChan; gotMsg.Type != ChatBlockListResult || gotMsg.Payload["users"] != `["`+sender.uuid+`"]` {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	receiver.resolveMessage(Message{Type: ChatUnblock, Payload: map[string]string{"userUUID": sender.uuid}})
+
+	// the blocked message counts towards the rate limit
+	for i := 1; i < chatRateLimit; i++ {
+		say(sender, "hi")
+		if gotMsg := <-receiver.writeChan; gotMsg.Type != MessageNew {
+			t.Errorf("invalid write message %v", gotMsg)
+		}
+	}
+	say(sender, "one more")
+	expectError(sender, "RATE_LIMITED")
+
+	defer func(key string) { adminKey = key }(adminKey)
+	adminKey = "secret"
+	mute := func(key, duration string) {
+		sender.resolveMessage(Message{Type: ChatMute, Payload: map[string]string{"key": key, "userUUID": receiver.uuid, "duration": duration}})
+	}
+	mute("guess", "60")
+	expectError(sender, "FORBIDDEN")
+	mute("secret", "60")
+	if gotMsg := <-receiver.writeChan; gotMsg.Type != ChatMuted || gotMsg.Payload["until"] == "" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	say(receiver, "hello?")
+	expectError(receiver, "MUTED")
+	mute("secret", "0")
+	if gotMsg := <-receiver.writeChan; gotMsg.Type != ChatUnmuted {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	say(receiver, "hello")
+	if gotMsg := <-sender.writeChan; gotMsg.Type != MessageNew || gotMsg.Payload["text"] != "hello" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
+
+func TestUser_ModerationFollowsAccount(t *testing.T) {
+	defer func(key string) { adminKey = key }(adminKey)
+	adminKey = "secret"
+	repository := InmemoryRepository()
+	member, moderator := MockUserWithRepository(repository), MockUserWithRepository(repository)
+	member.resolveMessage(Message{Type: Register, Payload: map[string]string{"username": "member", "password": "correct horse"}})
+	<-member.writeChan
+	moderator.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "moderator"}})
+	<-moderator.writeChan
+
+	moderator.resolveMessage(Message{Type: ChatBlock, Payload: map[string]string{"userUUID": member.uuid}})
+	moderator.resolveMessage(Message{Type: ChatMute, Payload: map[string]string{"key": "secret", "userUUID": member.uuid, "duration": "60"}})
+	if gotMsg := <-member.writeChan; gotMsg.Type != ChatMuted {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	member.close()
+
+	// a new session of the account is still muted and blocked
+	session := MockUserWithRepository(repository)
+	session.resolveMessage(Message{Type: Login, Payload: map[string]string{"username": "member", "password": "correct horse"}})
+	if gotMsg := <-session.writeChan; gotMsg.Type != LoginSuccess {
+		t.Fatalf("invalid write message %v", gotMsg)
+	}
+	if session.mutedTill(time.Now()).IsZero() {
+		t.Errorf("the mute didn't follow the account")
+	}
+	if !moderator.blocks(session) {
+		t.Errorf("the block didn't follow the account")
+	}
+	moderator.resolveMessage(Message{Type: ChatBlockList})
+	if gotMsg := <-moderator.writeChan; gotMsg.Payload["users"] != `["`+session.account()+`"]` {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
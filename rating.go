@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	defaultRating = 1200
+	// eloK is the maximum rating change after a single game
+	eloK = 32
+	// the rating difference accepted right away and how fast it widens
+	ratingWindowBase         = 100
+	ratingWindowGrowthPerSec = 10
+)
+
+// expectedScore is the probability of a player rated rating to beat opponent
+func expectedScore(rating, opponent int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponent-rating)/400))
+}
+
+// eloRating returns the new rating after the game with opponent,
+// score is 1 for a win, 0.5 for a draw and 0 for a loss.
+func eloRating(rating, opponent int, score float64) int {
+	return rating + int(math.Round(eloK*(score-expectedScore(rating, opponent))))
+}
+
+// updateRatings applies the result of the game to both players ratings
+func updateRatings(crossUser, zeroUser *User, winner GameUnit) {
+	crossScore := 0.5
+	switch winner {
+	case CROSS:
+		crossScore = 1
+	case ZERO:
+		crossScore = 0
+	}
+	crossRating, zeroRating := crossUser.currentRating(), zeroUser.currentRating()
+	crossUser.setRating(eloRating(crossRating, zeroRating, crossScore))
+	zeroUser.setRating(eloRating(zeroRating, crossRating, 1-crossScore))
+}
+
+// ratingWindow is the rating difference a player accepts after waiting
+func ratingWindow(waited time.Duration) int {
+	if waited < 0 {
+		waited = 0
+	}
+	return ratingWindowBase + int(waited.Seconds()*ratingWindowGrowthPerSec)
+}
+
+func ratingDiff(first, second *User) int {
+	diff := first.currentRating() - second.currentRating()
+	if diff < 0 {
+		return -diff
+	}
+	return diff
+}
+
+// ratingsMatch checks that both players accept each other's ratings
+func ratingsMatch(first, second *User, now time.Time) bool {
+	diff := ratingDiff(first, second)
+	_, firstStartedAt := first.search()
+	_, secondStartedAt := second.search()
+	return diff <= ratingWindow(now.Sub(firstStartedAt)) &&
+		diff <= ratingWindow(now.Sub(secondStartedAt))
+}
+
+// expectedWait estimates how long user will wait until one of the
+// candidates falls into both windows, false when there is nobody to wait for.
+func expectedWait(user *User, candidates []*User, now time.Time) (time.Duration, bool) {
+	var best time.Duration
+	found := false
+	variant, startedAt := user.search()
+	for _, candidate := range candidates {
+		candidateVariant, candidateStartedAt := candidate.search()
+		if candidate == user || candidateVariant != variant {
+			continue
+		}
+		diff := ratingDiff(user, candidate)
+		// time both windows need to reach diff since the search started
+		need := time.Duration(float64(diff-ratingWindowBase) / ratingWindowGrowthPerSec * float64(time.Second))
+		wait := need - now.Sub(startedAt)
+		if candidateWait := need - now.Sub(candidateStartedAt); candidateWait > wait {
+			wait = candidateWait
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		if !found || wait < best {
+			best = wait
+			found = true
+		}
+	}
+	return best, found
+}
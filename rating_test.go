@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_eloRating(t *testing.T) {
+	tests := []struct {
+		name     string
+		rating   int
+		opponent int
+		score    float64
+		want     int
+	}{
+		{"equal players win", 1200, 1200, 1, 1216},
+		{"equal players draw", 1200, 1200, 0.5, 1200},
+		{"equal players loss", 1200, 1200, 0, 1184},
+		{"favourite wins", 1600, 1200, 1, 1603},
+		{"underdog wins", 1200, 1600, 1, 1229},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eloRating(tt.rating, tt.opponent, tt.score); got != tt.want {
+				t.Errorf("eloRating() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ratingsMatch(t *testing.T) {
+	now := time.Now()
+	newcomer, veteran := MockUser(), MockUser()
+	veteran.rating = 1600
+
+	newcomer.searchStartedAt = now
+	veteran.searchStartedAt = now
+	if ratingsMatch(newcomer, veteran, now) {
+		t.Errorf("newcomer and veteran shouldn't be matched right away")
+	}
+	wait, ok := expectedWait(newcomer, []*User{newcomer, veteran}, now)
+	if !ok || wait != 30*time.Second {
+		t.Errorf("expectedWait() = %v, %v, want %v, %v", wait, ok, 30*time.Second, true)
+	}
+
+	later := now.Add(30 * time.Second)
+	if !ratingsMatch(newcomer, veteran, later) {
+		t.Errorf("window should widen after waiting")
+	}
+}
+
+func Test_expectedWait_NoCandidates(t *testing.T) {
+	user, other := MockUser(), MockUser()
+	other.searchVariant = rulesDefaults[ConnectFour]
+	if _, ok := expectedWait(user, []*User{user, other}, time.Now()); ok {
+		t.Errorf("expectedWait() without candidates should be unknown")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// rematchOpponent returns the replay of the last game of the user and the
+// opponent to play its rematch with
+func (u *User) rematchOpponent() (*Replay, *User, error) {
+	if u.gameUUID() != "" {
+		return nil, nil, errAlreadyInGame
+	}
+	replay := u.repository.ReplayByUUID(u.lastGame())
+	if replay == nil {
+		return nil, nil, errNoRematch
+	}
+	opponentUUID := replay.crossUserUUID
+	if opponentUUID == u.uuid {
+		opponentUUID = replay.zeroUserUUID
+	}
+	opponent := u.repository.UserByUUID(opponentUUID)
+	if opponent == nil || opponent.gameUUID() != "" || opponent.lastGame() != replay.gameUUID {
+		return nil, nil, errOpponentAway
+	}
+	if sameAccount(opponent, u) {
+		return nil, nil, errSameAccount
+	}
+	return replay, opponent, nil
+}
+
+// requestRematch asks the last opponent for a rematch, the request of both
+// players starts it right away
+func (u *User) requestRematch() error {
+	replay, opponent, err := u.rematchOpponent()
+	if err != nil {
+		return err
+	}
+	if opponent.takeRematch(replay.gameUUID) {
+		return u.startRematch(replay, opponent)
+	}
+	u.setRematch(replay.gameUUID)
+	opponent.send(Message{
+		Type: RematchRequest,
+		Payload: map[string]string{
+			"userUUID": u.uuid,
+			"gameUUID": replay.gameUUID,
+		},
+	})
+	return nil
+}
+
+func (u *User) acceptRematch() error {
+	replay, opponent, err := u.rematchOpponent()
+	if err != nil {
+		return err
+	}
+	if !opponent.takeRematch(replay.gameUUID) {
+		return errNoRematch
+	}
+	return u.startRematch(replay, opponent)
+}
+
+// startRematch starts the new game with the colors of the players swapped
+func (u *User) startRematch(replay *Replay, opponent *User) error {
+	u.setRematch("")
+	crossUser, zeroUser := u, opponent
+	if replay.crossUserUUID == u.uuid {
+		crossUser, zeroUser = opponent, u
+	}
+	return startGame(u.repository, crossUser, zeroUser, replay.variant)
+}
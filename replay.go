@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// results of a finished game in the replay notation
+const (
+	resultCrossWins = "1-0"
+	resultZeroWins  = "0-1"
+	resultDraw      = "1/2"
+	// resultAborted means a player left before the first move
+	resultAborted = "*"
+)
+
+// MoveRecord is a single move of the game history
+type MoveRecord struct {
+	unit     GameUnit
+	userUUID string
+	// position is the position the player asked for, rules map it to a cell
+	position int
+	movedAt  time.Time
+}
+
+// Replay is the history of a finished game
+type Replay struct {
+	gameUUID      string
+	variant       GameVariant
+	crossUserUUID string
+	zeroUserUUID  string
+	result        string
+	startedAt     time.Time
+	moves         []MoveRecord
+}
+
+// resultOf returns the replay result of a decided game
+func resultOf(winner GameUnit) string {
+	switch winner {
+	case CROSS:
+		return resultCrossWins
+	case ZERO:
+		return resultZeroWins
+	}
+	return resultDraw
+}
+
+// Notation exports the replay as a single line:
+//
+//	<game> <width>x<height>x<winLength>[/<time>+<increment>+<move time>] <started at, unix ms> <cross uuid> <zero uuid> <result> <moves>
+//
+// where the time control is in ms and only written for timed games, moves
+// are "<position>@<ms since the start>" separated by spaces, crosses move
+// first and the players alternate.
+func (r *Replay) Notation() string {
+	board := fmt.Sprintf("%vx%vx%v", r.variant.width, r.variant.height, r.variant.winLength)
+	if control := r.variant.timeControl; control.Enabled() {
+		board += fmt.Sprintf("/%v+%v+%v", durationMillis(control.total), durationMillis(control.increment), durationMillis(control.perMove))
+	}
+	header := fmt.Sprintf("%v %v %v %v %v %v",
+		r.variant.rules, board, unixMillis(r.startedAt), r.crossUserUUID, r.zeroUserUUID, r.result)
+	moves := formatMoves(r.startedAt, r.moves)
+	if moves == "" {
+		return header
+	}
+	return header + " " + moves
+}
+
+// ParseReplay reads the replay of game gameUUID exported by Notation
+func ParseReplay(gameUUID, notation string) (*Replay, error) {
+	fields := strings.Fields(notation)
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("replay %v: truncated notation", gameUUID)
+	}
+	replay := &Replay{
+		gameUUID,
+		GameVariant{rules: fields[0]},
+		fields[3],
+		fields[4],
+		fields[5],
+		time.Time{},
+		nil,
+	}
+	board := strings.SplitN(fields[1], "/", 2)
+	_, err := fmt.Sscanf(board[0], "%dx%dx%d", &replay.variant.width, &replay.variant.height, &replay.variant.winLength)
+	if err != nil {
+		return nil, fmt.Errorf("replay %v: %v", gameUUID, err)
+	}
+	if len(board) == 2 {
+		var total, increment, perMove int64
+		_, err = fmt.Sscanf(board[1], "%d+%d+%d", &total, &increment, &perMove)
+		if err != nil {
+			return nil, fmt.Errorf("replay %v: time control: %v", gameUUID, err)
+		}
+		replay.variant.timeControl = TimeControl{
+			time.Duration(total) * time.Millisecond,
+			time.Duration(increment) * time.Millisecond,
+			time.Duration(perMove) * time.Millisecond,
+		}
+	}
+	startedAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("replay %v: %v", gameUUID, err)
+	}
+	replay.startedAt = fromUnixMillis(startedAt)
+	replay.moves, err = parseMoves(replay.startedAt, replay.crossUserUUID, replay.zeroUserUUID, strings.Join(fields[6:], " "))
+	if err != nil {
+		return nil, fmt.Errorf("replay %v: %v", gameUUID, err)
+	}
+	return replay, nil
+}
+
+// formatMoves writes moves in the notation used by Replay.Notation
+func formatMoves(startedAt time.Time, moves []MoveRecord) string {
+	tokens := make([]string, len(moves))
+	for i, move := range moves {
+		tokens[i] = fmt.Sprintf("%v@%v", move.position, move.movedAt.Sub(startedAt).Nanoseconds()/int64(time.Millisecond))
+	}
+	return strings.Join(tokens, " ")
+}
+
+// parseMoves reads moves written by formatMoves
+func parseMoves(startedAt time.Time, crossUserUUID, zeroUserUUID, text string) ([]MoveRecord, error) {
+	var moves []MoveRecord
+	for i, token := range strings.Fields(text) {
+		var position int
+		var offset int64
+		_, err := fmt.Sscanf(token, "%d@%d", &position, &offset)
+		if err != nil {
+			return nil, fmt.Errorf("move %v: %v", i+1, err)
+		}
+		move := MoveRecord{CROSS, crossUserUUID, position, startedAt.Add(time.Duration(offset) * time.Millisecond)}
+		if i%2 == 1 {
+			move.unit = ZERO
+			move.userUUID = zeroUserUUID
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+// payload converts the replay to the GameReplayResult payload, moves are
+// a JSON list since payload values are strings
+func (r *Replay) payload() map[string]string {
+	moves := make([]map[string]string, len(r.moves))
+	for i, move := range r.moves {
+		moves[i] = map[string]string{
+			"unit":     string(move.unit),
+			"userUUID": move.userUUID,
+			"position": strconv.Itoa(move.position),
+			"movedAt":  move.movedAt.UTC().Format(time.RFC3339Nano),
+		}
+	}
+	encoded, _ := json.Marshal(moves)
+	return map[string]string{
+		"gameUUID":      r.gameUUID,
+		"game":          r.variant.rules,
+		"width":         strconv.Itoa(r.variant.width),
+		"height":        strconv.Itoa(r.variant.height),
+		"winLength":     strconv.Itoa(r.variant.winLength),
+		"crossUserUUID": r.crossUserUUID,
+		"zeroUserUUID":  r.zeroUserUUID,
+		"result":        r.result,
+		"startedAt":     r.startedAt.UTC().Format(time.RFC3339Nano),
+		"moves":         string(encoded),
+		"notation":      r.Notation(),
+	}
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplay_Notation(t *testing.T) {
+	startedAt := fromUnixMillis(1500000000000)
+	replay := &Replay{
+		"game",
+		rulesDefaults[ConnectFour],
+		"cross",
+		"zero",
+		resultZeroWins,
+		startedAt,
+		[]MoveRecord{
+			{CROSS, "cross", 4, startedAt.Add(1500 * time.Millisecond)},
+			{ZERO, "zero", 3, startedAt.Add(2750 * time.Millisecond)},
+		},
+	}
+	want := "connectfour 7x6x4 1500000000000 cross zero 0-1 4@1500 3@2750"
+	if got := replay.Notation(); got != want {
+		t.Errorf("Replay.Notation() = %v, want %v", got, want)
+	}
+	parsed, err := ParseReplay("game", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, replay) {
+		t.Errorf("ParseReplay() = %v, want %v", parsed, replay)
+	}
+}
+
+func TestReplay_NotationTimeControl(t *testing.T) {
+	variant := rulesDefaults[TicTacToe]
+	variant.timeControl = TimeControl{time.Minute, 2 * time.Second, 10 * time.Second}
+	replay := &Replay{"game", variant, "cross", "zero", resultCrossWins, fromUnixMillis(1500000000000), nil}
+	want := "tictactoe 3x3x3/60000+2000+10000 1500000000000 cross zero 1-0"
+	if got := replay.Notation(); got != want {
+		t.Errorf("Replay.Notation() = %v, want %v", got, want)
+	}
+	parsed, err := ParseReplay("game", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, replay) {
+		t.Errorf("ParseReplay() = %v, want %v", parsed, replay)
+	}
+}
+
+func TestParseReplay_Invalid(t *testing.T) {
+	for _, notation := range []string{
+		"",
+		"tictactoe 3x3x3 0 cross zero",
+		"tictactoe 3x3 0 cross zero 1-0",
+		"tictactoe 3x3x3 0 cross zero 1-0 1@",
+		"tictactoe 3x3x3/60000 0 cross zero 1-0",
+	} {
+		if _, err := ParseReplay("game", notation); err == nil {
+			t.Errorf("ParseReplay(%q) should fail", notation)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	TicTacToe   = "tictactoe"
+	ConnectFour = "connectfour"
+)
+
+const (
+	minBoardSize = 3
+	maxBoardSize = 15
+)
+
+// Rules implements a turn based game for two players placing units on a field.
+// Rules are stateless, the field itself is owned by Game.
+type Rules interface {
+	// NewField returns the field of a game that hasn't started yet
+	NewField() GameField
+	// ValidateMove checks that unit is allowed to move to position
+	ValidateMove(field GameField, unit GameUnit, position int) error
+	// ApplyMove puts unit on the field and returns the cell it took
+	ApplyMove(field GameField, unit GameUnit, position int) int
+	// Outcome reports whether the game is over and who won, EMPTY winner is a draw
+	Outcome(field GameField) (winner GameUnit, over bool)
+	// Serialize converts the field to a message payload
+	Serialize(field GameField) map[string]string
+}
+
+var rulesDefaults = map[string]GameVariant{
+	TicTacToe:   {TicTacToe, 3, 3, 3, TimeControl{}},
+	ConnectFour: {ConnectFour, 7, 6, 4, TimeControl{}},
+}
+
+var rulesConstructors = map[string]func(v GameVariant) Rules{
+	TicTacToe: func(v GameVariant) Rules {
+		return &TicTacToeRules{board{v.width, v.height, v.winLength}}
+	},
+	ConnectFour: func(v GameVariant) Rules {
+		return &ConnectFourRules{board{v.width, v.height, v.winLength}}
+	},
+}
+
+// GameVariant describes which game is played and on which board:
+// width x height cells where winLength units in a row win.
+type GameVariant struct {
+	rules       string
+	width       int
+	height      int
+	winLength   int
+	timeControl TimeControl
+}
+
+var DefaultGameVariant = rulesDefaults[TicTacToe]
+
+// ParseGameVariant reads the variant from a message payload,
+// missing values fall back to the defaults of the requested game
+// and the game is played without a time control.
+func ParseGameVariant(payload map[string]string) (GameVariant, bool) {
+	name, ok := payload["game"]
+	if !ok {
+		name = TicTacToe
+	}
+	variant, ok := rulesDefaults[name]
+	if !ok {
+		return variant, false
+	}
+	for key, value := range map[string]*int{
+		"width":     &variant.width,
+		"height":    &variant.height,
+		"winLength": &variant.winLength,
+	} {
+		raw, ok := payload[key]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return variant, false
+		}
+		*value = parsed
+	}
+	for i, value := range variant.timeControl.fields() {
+		raw, ok := payload[timeControlKeys[i]]
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.Atoi(raw)
+		if err != nil {
+			return variant, false
+		}
+		*value = time.Duration(seconds) * time.Second
+	}
+	return variant, variant.Valid()
+}
+
+func (v GameVariant) Valid() bool {
+	if _, ok := rulesConstructors[v.rules]; !ok {
+		return false
+	}
+	if v.width < minBoardSize || v.width > maxBoardSize {
+		return false
+	}
+	if v.height < minBoardSize || v.height > maxBoardSize {
+		return false
+	}
+	if !v.timeControl.Valid() {
+		return false
+	}
+	return v.winLength >= minBoardSize && (v.winLength <= v.width || v.winLength <= v.height)
+}
+
+func (v GameVariant) Rules() Rules {
+	return rulesConstructors[v.rules](v)
+}
+
+// directions to walk from a cell when looking for a winning line:
+// right, down, down-right and down-left
+var winDirections = [][2]int{
+	{1, 0},
+	{0, 1},
+	{1, 1},
+	{-1, 1},
+}
+
+// board holds the geometry shared by games played on a rectangular field,
+// cells are numbered row by row starting from 1 in the top left corner.
+type board struct {
+	width     int
+	height    int
+	winLength int
+}
+
+// position returns the field key of the cell in column x and row y
+// (both zero based) or 0 when the cell is outside of the board.
+func (b board) position(x, y int) int {
+	if x < 0 || x >= b.width || y < 0 || y >= b.height {
+		return 0
+	}
+	return y*b.width + x + 1
+}
+
+func (b board) NewField() GameField {
+	field := GameField{}
+	for position := 1; position <= b.width*b.height; position++ {
+		field[position] = EMPTY
+	}
+	return field
+}
+
+func (b board) Outcome(field GameField) (GameUnit, bool) {
+	full := true
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			unit := field[b.position(x, y)]
+			if unit == EMPTY {
+				full = false
+				continue
+			}
+			for _, direction := range winDirections {
+				length := 1
+				for length < b.winLength {
+					position := b.position(x+direction[0]*length, y+direction[1]*length)
+					if position == 0 || field[position] != unit {
+						break
+					}
+					length++
+				}
+				if length == b.winLength {
+					return unit, true
+				}
+			}
+		}
+	}
+	return EMPTY, full
+}
+
+func (b board) Serialize(field GameField) map[string]string {
+	payload := map[string]string{}
+	for k, v := range field {
+		payload[strconv.Itoa(k)] = string(v)
+	}
+	return payload
+}
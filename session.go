@@ -0,0 +1,171 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"log"
+	"time"
+)
+
+// reconnectGracePeriod is how long a user in a game survives without a socket
+var reconnectGracePeriod = 30 * time.Second
+
+func userByReconnectToken(repository IRepository, token string) *User {
+	if token == "" {
+		return nil
+	}
+	for _, user := range repository.Users() {
+		if user.reconnectToken == token {
+			return user
+		}
+	}
+	return nil
+}
+
+// connection returns the socket currently bound to the user
+func (u *User) connection() *websocket.Conn {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.ws
+}
+
+func (u *User) isDetached() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.detached
+}
+
+// attach binds the socket to the user and starts serving it,
+// a socket bound before is closed.
+func (u *User) attach(ws *websocket.Conn) {
+	u.mutex.Lock()
+	if u.detachTimer != nil {
+		u.detachTimer.Stop()
+		u.detachTimer = nil
+	}
+	if u.quit != nil {
+		close(u.quit)
+	}
+	previous := u.ws
+	u.ws = ws
+	u.quit = make(chan struct{})
+	u.done = make(chan struct{})
+	u.detached = false
+	// whatever was queued for the old socket is stale now
+drain:
+	for {
+		select {
+		case <-u.writeChan:
+		default:
+			break drain
+		}
+	}
+	quit, done := u.quit, u.done
+	u.mutex.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	go u.readLoop(ws)
+	go u.writeLoop(ws, quit, done)
+}
+
+// detach keeps the user without a socket for reconnectGracePeriod,
+// after that the user is closed like on a regular disconnect.
+func (u *User) detach() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.quit != nil {
+		close(u.quit)
+		u.quit = nil
+	}
+	u.ws = nil
+	u.detached = true
+	if u.detachTimer != nil {
+		u.detachTimer.Stop()
+	}
+	u.detachTimer = time.AfterFunc(reconnectGracePeriod, u.expire)
+}
+
+func (u *User) expire() {
+	u.mutex.Lock()
+	detached := u.detached
+	u.detachTimer = nil
+	u.mutex.Unlock()
+	if detached {
+		log.Printf("user %v didn't reconnect in time", u.uuid)
+		u.close()
+	}
+}
+
+// disconnect handles the loss of ws: users in a game are detached and may
+// Resume, everybody else is closed right away.
+func (u *User) disconnect(ws *websocket.Conn) {
+	if u.connection() != ws {
+		// the user is already served by another socket
+		return
+	}
+	if u.gameUUID() != "" && u.repository.UserByUUID(u.uuid) != nil {
+		log.Printf("user %v detached", u.uuid)
+		u.detach()
+		u.announcePresence()
+		return
+	}
+	u.mutex.Lock()
+	if u.quit != nil {
+		close(u.quit)
+		u.quit = nil
+	}
+	u.ws = nil
+	u.mutex.Unlock()
+	u.close()
+}
+
+// handOver moves the socket of this connection to target, which is the user
+// the connection resumes, and replays target's current game. ResumeSuccess
+// carries requestID of the Resume message.
+func (u *User) handOver(target *User, requestID string) {
+	u.mutex.Lock()
+	ws, done := u.ws, u.done
+	if u.quit != nil {
+		close(u.quit)
+		u.quit = nil
+	}
+	u.ws = nil
+	u.mutex.Unlock()
+	if u.repository.UserByUUID(u.uuid) != nil {
+		u.close()
+	}
+	// a socket allows a single writer, the write loop of target starts
+	// once ours is gone
+	if done != nil {
+		<-done
+	}
+
+	log.Printf("user %v resumed", target.uuid)
+	target.attach(ws)
+	target.announcePresence()
+	target.send(Message{
+		ID:   requestID,
+		Type: ResumeSuccess,
+		Payload: map[string]string{
+			"uuid":     target.uuid,
+			"username": target.name(),
+		},
+	})
+	game := target.repository.GameByUUID(target.gameUUID())
+	if game == nil {
+		return
+	}
+	state, field, ok := game.snapshot()
+	if !ok {
+		return
+	}
+	target.send(Message{
+		Type:    GameResumed,
+		Payload: state,
+	})
+	target.send(Message{
+		Type:    GameMoved,
+		Payload: field,
+	})
+}
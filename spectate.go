@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// maxListedGames bounds the GameList response, the newest games go first
+const maxListedGames = 50
+
+// broadcast sends message to the players and the spectators
+func (g *Game) broadcast(message Message) {
+	for _, user := range g.users {
+		user.send(message)
+	}
+	for spectator := range g.spectators {
+		spectator.send(message)
+	}
+}
+
+// Watch subscribes user to the game and sends the current state to it,
+// GameWatchStarted carries requestID of the GameWatch message
+func (g *Game) Watch(user *User, requestID string) error {
+	var err error = errGameNotFound
+	g.do(func() {
+		if g.isOver {
+			return
+		}
+		err = nil
+		g.spectators[user] = true
+		state := g.describe()
+		state["currentMoveUnit"] = string(g.currentMoveUnit)
+		state["spectators"] = strconv.Itoa(len(g.spectators))
+		g.describeClock(state)
+		user.send(Message{
+			ID:      requestID,
+			Type:    GameWatchStarted,
+			Payload: state,
+		})
+		user.send(Message{
+			Type:    GameMoved,
+			Payload: g.GetField(),
+		})
+		g.notifySpectators()
+	})
+	return err
+}
+
+// Unwatch unsubscribes user from the game
+func (g *Game) Unwatch(user *User) {
+	g.do(func() {
+		if !g.spectators[user] {
+			return
+		}
+		delete(g.spectators, user)
+		if !g.isOver {
+			g.notifySpectators()
+		}
+	})
+}
+
+// notifySpectators tells the players how many users watch them
+func (g *Game) notifySpectators() {
+	message := Message{
+		Type:    GameSpectators,
+		Payload: map[string]string{"spectators": strconv.Itoa(len(g.spectators))},
+	}
+	for _, user := range g.users {
+		user.send(message)
+	}
+}
+
+// summary describes a running game for GameList, false for finished games
+// and for games too busy to answer
+func (g *Game) summary() (map[string]string, bool) {
+	var summary map[string]string
+	ok := false
+	answered := g.doWithin(gameQueryTimeout, func() {
+		if g.isOver {
+			return
+		}
+		ok = true
+		summary = g.describe()
+		summary["crossUsername"] = g.crossUser.name()
+		summary["zeroUsername"] = g.zeroUser.name()
+		summary["moves"] = strconv.Itoa(len(g.moves))
+		summary["spectators"] = strconv.Itoa(len(g.spectators))
+		summary["startedAt"] = strconv.FormatInt(unixMillis(g.startedAt), 10)
+	})
+	if !answered {
+		return nil, false
+	}
+	return summary, ok
+}
+
+// liveGames returns the summaries of the running games, newest first
+func liveGames(repository IRepository) string {
+	games := []map[string]string{}
+	for _, game := range repository.GameSessions() {
+		summary, ok := game.summary()
+		if ok {
+			games = append(games, summary)
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		first, _ := strconv.ParseInt(games[i]["startedAt"], 10, 64)
+		second, _ := strconv.ParseInt(games[j]["startedAt"], 10, 64)
+		return first > second
+	})
+	if len(games) > maxListedGames {
+		games = games[:maxListedGames]
+	}
+	encoded, _ := json.Marshal(games)
+	return string(encoded)
+}
+
+// watch makes the user a spectator of the game, leaving the game it
+// watched before
+func (u *User) watch(gameUUID, requestID string) error {
+	if u.repository.UserByUUID(u.uuid) == nil {
+		return errNotLoggedIn
+	}
+	if u.gameUUID() != "" {
+		return errAlreadyInGame
+	}
+	game := u.repository.GameByUUID(gameUUID)
+	if game == nil {
+		return errGameNotFound
+	}
+	u.unwatch()
+	u.mutex.Lock()
+	u.watchingGameUUID = gameUUID
+	u.mutex.Unlock()
+	err := game.Watch(u, requestID)
+	if err != nil {
+		u.stopWatching(gameUUID)
+	}
+	return err
+}
+
+func (u *User) unwatch() {
+	u.mutex.Lock()
+	gameUUID := u.watchingGameUUID
+	u.watchingGameUUID = ""
+	u.mutex.Unlock()
+	if gameUUID == "" {
+		return
+	}
+	game := u.repository.GameByUUID(gameUUID)
+	if game != nil {
+		game.Unwatch(u)
+	}
+}
+
+func (u *User) watching() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.watchingGameUUID
+}
+
+// stopWatching forgets gameUUID when the user still watches it
+func (u *User) stopWatching(gameUUID string) {
+	u.mutex.Lock()
+	if u.watchingGameUUID == gameUUID {
+		u.watchingGameUUID = ""
+	}
+	u.mutex.Unlock()
+}
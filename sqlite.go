@@ -0,0 +1,719 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	uuid              TEXT PRIMARY KEY,
+	username          TEXT NOT NULL,
+	current_game_uuid TEXT NOT NULL DEFAULT '',
+	rating            INTEGER NOT NULL,
+	reconnect_token   TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS users_in_search (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_uuid  TEXT NOT NULL UNIQUE,
+	rules      TEXT NOT NULL,
+	width      INTEGER NOT NULL,
+	height     INTEGER NOT NULL,
+	win_length INTEGER NOT NULL,
+	started_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS games (
+	uuid              TEXT PRIMARY KEY,
+	cross_user_uuid   TEXT NOT NULL,
+	zero_user_uuid    TEXT NOT NULL,
+	current_move_unit TEXT NOT NULL,
+	is_over           INTEGER NOT NULL DEFAULT 0,
+	field             TEXT NOT NULL,
+	rules             TEXT NOT NULL,
+	width             INTEGER NOT NULL,
+	height            INTEGER NOT NULL,
+	win_length        INTEGER NOT NULL
+);
+`
+
+// sqliteMigrations upgrade databases created by earlier versions,
+// PRAGMA user_version stores how many of them were applied
+var sqliteMigrations = []string{
+	`ALTER TABLE games ADD COLUMN started_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN moves TEXT NOT NULL DEFAULT '';
+	CREATE TABLE replays (
+		game_uuid   TEXT PRIMARY KEY,
+		notation    TEXT NOT NULL,
+		finished_at INTEGER NOT NULL
+	);`,
+	// time controls and clocks, durations are milliseconds
+	`ALTER TABLE users_in_search ADD COLUMN time_total INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users_in_search ADD COLUMN time_increment INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users_in_search ADD COLUMN move_time INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN time_total INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN time_increment INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN move_time INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN cross_time_left INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE games ADD COLUMN zero_time_left INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE invites (
+		code           TEXT PRIMARY KEY,
+		creator_uuid   TEXT NOT NULL,
+		rules          TEXT NOT NULL,
+		width          INTEGER NOT NULL,
+		height         INTEGER NOT NULL,
+		win_length     INTEGER NOT NULL,
+		time_total     INTEGER NOT NULL,
+		time_increment INTEGER NOT NULL,
+		move_time      INTEGER NOT NULL,
+		expires_at     INTEGER NOT NULL
+	);`,
+	`CREATE TABLE chat_messages (
+		seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel     TEXT NOT NULL,
+		sender_uuid TEXT NOT NULL,
+		username    TEXT NOT NULL,
+		text        TEXT NOT NULL,
+		sent_at     INTEGER NOT NULL
+	);
+	CREATE INDEX chat_messages_channel ON chat_messages (channel, seq);`,
+	// blocked is a space separated list of user uuids
+	`ALTER TABLE users ADD COLUMN blocked TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN muted_until INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE accounts (
+		id         TEXT PRIMARY KEY,
+		username   TEXT NOT NULL,
+		rating     INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	ALTER TABLE users ADD COLUMN account_id TEXT NOT NULL DEFAULT '';`,
+	// username_key is the lower case username, lower() of sqlite folds ASCII only
+	`ALTER TABLE accounts ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+	ALTER TABLE accounts ADD COLUMN username_key TEXT NOT NULL DEFAULT '';
+	UPDATE accounts SET username_key = lower(username);
+	CREATE INDEX accounts_username ON accounts (username_key);`,
+	// total_duration is milliseconds
+	`CREATE TABLE stats (
+		account_id     TEXT PRIMARY KEY,
+		wins           INTEGER NOT NULL,
+		losses         INTEGER NOT NULL,
+		draws          INTEGER NOT NULL,
+		streak         INTEGER NOT NULL,
+		best_streak    INTEGER NOT NULL,
+		cross_games    INTEGER NOT NULL,
+		zero_games     INTEGER NOT NULL,
+		total_duration INTEGER NOT NULL
+	);`,
+	`CREATE TABLE game_results (
+		seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id  TEXT NOT NULL,
+		outcome     TEXT NOT NULL,
+		rating      INTEGER NOT NULL,
+		finished_at INTEGER NOT NULL
+	);
+	CREATE INDEX game_results_finished_at ON game_results (finished_at);`,
+	`CREATE TABLE friendships (
+		requester_id TEXT NOT NULL,
+		addressee_id TEXT NOT NULL,
+		accepted     INTEGER NOT NULL,
+		created_at   INTEGER NOT NULL,
+		PRIMARY KEY (requester_id, addressee_id)
+	);
+	CREATE INDEX friendships_addressee ON friendships (addressee_id);`,
+	// blocked is a space separated list of identities, see User.identity
+	`ALTER TABLE accounts ADD COLUMN blocked TEXT NOT NULL DEFAULT '';
+	ALTER TABLE accounts ADD COLUMN muted_until INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE chat_messages ADD COLUMN sender_account_id TEXT NOT NULL DEFAULT '';`,
+	`CREATE INDEX game_results_account ON game_results (account_id, finished_at, seq);`,
+}
+
+// SqliteRepository opens (or creates) the database at path and restores
+// users, the search queue and game sessions stored by a previous run.
+func SqliteRepository(path string) (IRepository, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer, serialize access on our side
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	sr := &SqliteGameRepository{
+		InmemoryRepository().(*GameRepository),
+		db,
+	}
+	err = sr.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = sr.load()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sr, nil
+}
+
+// SqliteGameRepository keeps live objects in memory like GameRepository
+// and writes every change through to sqlite.
+type SqliteGameRepository struct {
+	*GameRepository
+	db *sql.DB
+}
+
+func (sr *SqliteGameRepository) Close() error {
+	return sr.db.Close()
+}
+
+func (sr *SqliteGameRepository) migrate() error {
+	var version int
+	err := sr.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := sr.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(sqliteMigrations[version])
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %v: %v", version+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sr *SqliteGameRepository) load() error {
+	rows, err := sr.db.Query(`SELECT uuid, username, current_game_uuid, rating, reconnect_token, blocked, muted_until, account_id FROM users`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user := NewUser(sr)
+		var blocked string
+		var mutedUntil int64
+		err = rows.Scan(&user.uuid, &user.username, &user.currentGameUUID, &user.rating, &user.reconnectToken, &blocked, &mutedUntil, &user.accountID)
+		if err != nil {
+			return err
+		}
+		for _, userUUID := range strings.Fields(blocked) {
+			user.blocked[userUUID] = true
+		}
+		if mutedUntil > 0 {
+			user.mutedUntil = time.Unix(0, mutedUntil)
+		}
+		// sockets didn't survive the restart, wait for the clients to Resume
+		user.detach()
+		sr.GameRepository.AddUser(user)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = sr.db.Query(`SELECT user_uuid, rules, width, height, win_length, time_total, time_increment, move_time, started_at FROM users_in_search ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uuid string
+		var variant GameVariant
+		var startedAt int64
+		var timeControl [3]int64
+		err = rows.Scan(&uuid, &variant.rules, &variant.width, &variant.height, &variant.winLength,
+			&timeControl[0], &timeControl[1], &timeControl[2], &startedAt)
+		if err != nil {
+			return err
+		}
+		user := sr.UserByUUID(uuid)
+		if user == nil {
+			continue
+		}
+		variant.timeControl = TimeControl{
+			time.Duration(timeControl[0]) * time.Millisecond,
+			time.Duration(timeControl[1]) * time.Millisecond,
+			time.Duration(timeControl[2]) * time.Millisecond,
+		}
+		user.searchVariant = variant
+		user.searchStartedAt = time.Unix(0, startedAt)
+		sr.GameRepository.AddUserInSearch(user)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = sr.db.Query(`SELECT code, creator_uuid, rules, width, height, win_length, time_total, time_increment, move_time, expires_at FROM invites`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		invite := &Invite{}
+		var timeControl [3]int64
+		var expiresAt int64
+		err = rows.Scan(&invite.code, &invite.creatorUUID,
+			&invite.variant.rules, &invite.variant.width, &invite.variant.height, &invite.variant.winLength,
+			&timeControl[0], &timeControl[1], &timeControl[2], &expiresAt)
+		if err != nil {
+			return err
+		}
+		invite.variant.timeControl = TimeControl{
+			time.Duration(timeControl[0]) * time.Millisecond,
+			time.Duration(timeControl[1]) * time.Millisecond,
+			time.Duration(timeControl[2]) * time.Millisecond,
+		}
+		invite.expiresAt = time.Unix(0, expiresAt)
+		sr.GameRepository.AddInvite(invite)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = sr.db.Query(`SELECT uuid, cross_user_uuid, zero_user_uuid, current_move_unit, is_over, field, rules, width, height, win_length, started_at, moves,
+		time_total, time_increment, move_time, cross_time_left, zero_time_left FROM games`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var orphans []string
+	for rows.Next() {
+		var uuid, crossUserUUID, zeroUserUUID, field, moves string
+		var currentMoveUnit GameUnit
+		var isOver bool
+		var variant GameVariant
+		var startedAt int64
+		var clock [5]int64
+		err = rows.Scan(&uuid, &crossUserUUID, &zeroUserUUID, &currentMoveUnit, &isOver, &field,
+			&variant.rules, &variant.width, &variant.height, &variant.winLength, &startedAt, &moves,
+			&clock[0], &clock[1], &clock[2], &clock[3], &clock[4])
+		if err != nil {
+			return err
+		}
+		variant.timeControl = TimeControl{
+			time.Duration(clock[0]) * time.Millisecond,
+			time.Duration(clock[1]) * time.Millisecond,
+			time.Duration(clock[2]) * time.Millisecond,
+		}
+		if !variant.Valid() {
+			return fmt.Errorf("game %v has unknown variant %v", uuid, variant)
+		}
+		crossUser := sr.UserByUUID(crossUserUUID)
+		zeroUser := sr.UserByUUID(zeroUserUUID)
+		if crossUser == nil || zeroUser == nil {
+			// players are gone, nobody is able to finish this game
+			orphans = append(orphans, uuid)
+			continue
+		}
+		// the game goroutine doesn't touch the state until the first command
+		game := NewGame(sr, crossUser, zeroUser, variant)
+		game.uuid = uuid
+		game.currentMoveUnit = currentMoveUnit
+		game.isOver = isOver
+		game.startedAt = time.Unix(0, startedAt)
+		game.timeLeft[CROSS] = time.Duration(clock[3]) * time.Millisecond
+		game.timeLeft[ZERO] = time.Duration(clock[4]) * time.Millisecond
+		game.field = GameField{}
+		err = json.Unmarshal([]byte(field), &game.field)
+		if err == nil {
+			game.moves, err = parseMoves(game.startedAt, crossUserUUID, zeroUserUUID, moves)
+		}
+		if err != nil {
+			game.Stop()
+			return err
+		}
+		sr.GameRepository.AddGame(game)
+		// the time spent offline isn't charged, the current move starts over
+		game.do(game.startClock)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, uuid := range orphans {
+		_, err = sr.db.Exec(`DELETE FROM games WHERE uuid = ?`, uuid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sr *SqliteGameRepository) exec(query string, args ...interface{}) {
+	_, err := sr.db.Exec(query, args...)
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+	}
+}
+
+func (sr *SqliteGameRepository) AddUser(user *User) {
+	sr.GameRepository.AddUser(user)
+	sr.SaveUser(user)
+}
+
+func (sr *SqliteGameRepository) RemoveUser(user *User) {
+	sr.GameRepository.RemoveUser(user)
+	sr.exec(`DELETE FROM users_in_search WHERE user_uuid = ?`, user.uuid)
+	sr.exec(`DELETE FROM users WHERE uuid = ?`, user.uuid)
+}
+
+func (sr *SqliteGameRepository) SaveUser(user *User) {
+	if user.bot {
+		// bots live as long as their game, games with bots are dropped on restart
+		return
+	}
+	var mutedUntil int64
+	if muted := user.mutedTill(time.Now()); !muted.IsZero() {
+		mutedUntil = muted.UnixNano()
+	}
+	sr.exec(
+		`INSERT OR REPLACE INTO users (uuid, username, current_game_uuid, rating, reconnect_token, blocked, muted_until, account_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.uuid, user.name(), user.gameUUID(), user.currentRating(), user.reconnectToken,
+		strings.Join(user.blockedUsers(), " "), mutedUntil, user.account(),
+	)
+}
+
+func (sr *SqliteGameRepository) AddUserInSearch(user *User) {
+	sr.GameRepository.AddUserInSearch(user)
+	variant, startedAt := user.search()
+	sr.exec(
+		`INSERT OR IGNORE INTO users_in_search (user_uuid, rules, width, height, win_length, time_total, time_increment, move_time, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.uuid, variant.rules, variant.width, variant.height, variant.winLength,
+		durationMillis(variant.timeControl.total), durationMillis(variant.timeControl.increment), durationMillis(variant.timeControl.perMove),
+		startedAt.UnixNano(),
+	)
+}
+
+func (sr *SqliteGameRepository) RemoveUserInSearch(user *User) {
+	sr.GameRepository.RemoveUserInSearch(user)
+	sr.exec(`DELETE FROM users_in_search WHERE user_uuid = ?`, user.uuid)
+}
+
+// AddGame writes the game on its goroutine, it may be running already
+func (sr *SqliteGameRepository) AddGame(game *Game) {
+	sr.GameRepository.AddGame(game)
+	game.do(func() {
+		sr.SaveGame(game)
+	})
+}
+
+func (sr *SqliteGameRepository) RemoveGame(game *Game) {
+	sr.GameRepository.RemoveGame(game)
+	sr.exec(`DELETE FROM games WHERE uuid = ?`, game.uuid)
+	sr.exec(`DELETE FROM chat_messages WHERE channel = ?`, gameChannel(game.uuid))
+}
+
+// SaveGame reads the state of the game, call it on the game goroutine
+func (sr *SqliteGameRepository) SaveGame(game *Game) {
+	field, err := json.Marshal(game.field)
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return
+	}
+	control := game.variant.timeControl
+	sr.exec(
+		`INSERT OR REPLACE INTO games (uuid, cross_user_uuid, zero_user_uuid, current_move_unit, is_over, field, rules, width, height, win_length,
+			started_at, moves, time_total, time_increment, move_time, cross_time_left, zero_time_left)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		game.uuid, game.crossUser.uuid, game.zeroUser.uuid, game.currentMoveUnit, game.isOver, string(field),
+		game.variant.rules, game.variant.width, game.variant.height, game.variant.winLength,
+		game.startedAt.UnixNano(), formatMoves(game.startedAt, game.moves),
+		durationMillis(control.total), durationMillis(control.increment), durationMillis(control.perMove),
+		durationMillis(game.timeLeft[CROSS]), durationMillis(game.timeLeft[ZERO]),
+	)
+}
+
+func (sr *SqliteGameRepository) SaveReplay(replay *Replay) {
+	sr.GameRepository.SaveReplay(replay)
+	sr.exec(
+		`INSERT OR REPLACE INTO replays (game_uuid, notation, finished_at) VALUES (?, ?, ?)`,
+		replay.gameUUID, replay.Notation(), time.Now().UnixNano(),
+	)
+}
+
+// ReplayByUUID falls back to the database for replays evicted from memory
+// or stored by a previous run
+func (sr *SqliteGameRepository) ReplayByUUID(gameUUID string) *Replay {
+	replay := sr.GameRepository.ReplayByUUID(gameUUID)
+	if replay != nil {
+		return replay
+	}
+	var notation string
+	err := sr.db.QueryRow(`SELECT notation FROM replays WHERE game_uuid = ?`, gameUUID).Scan(&notation)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	replay, err = ParseReplay(gameUUID, notation)
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	return replay
+}
+
+func (sr *SqliteGameRepository) AddInvite(invite *Invite) {
+	sr.GameRepository.AddInvite(invite)
+	control := invite.variant.timeControl
+	sr.exec(
+		`INSERT OR IGNORE INTO invites (code, creator_uuid, rules, width, height, win_length, time_total, time_increment, move_time, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invite.code, invite.creatorUUID,
+		invite.variant.rules, invite.variant.width, invite.variant.height, invite.variant.winLength,
+		durationMillis(control.total), durationMillis(control.increment), durationMillis(control.perMove),
+		invite.expiresAt.UnixNano(),
+	)
+}
+
+func (sr *SqliteGameRepository) RemoveInvite(invite *Invite) {
+	sr.GameRepository.RemoveInvite(invite)
+	sr.exec(`DELETE FROM invites WHERE code = ?`, invite.code)
+}
+
+// AddChatEntry stores the entry, the database assigns seq and keeps the
+// last chatStoredHistory entries of the channel
+func (sr *SqliteGameRepository) AddChatEntry(entry *ChatEntry) {
+	result, err := sr.db.Exec(
+		`INSERT INTO chat_messages (channel, sender_uuid, sender_account_id, username, text, sent_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.channel, entry.senderUUID, entry.senderAccountID, entry.username, entry.text, entry.sentAt.UnixNano(),
+	)
+	if err == nil {
+		entry.seq, err = result.LastInsertId()
+	}
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return
+	}
+	sr.exec(
+		`DELETE FROM chat_messages WHERE channel = ? AND seq <= (
+			SELECT seq FROM chat_messages WHERE channel = ? ORDER BY seq DESC LIMIT 1 OFFSET ?)`,
+		entry.channel, entry.channel, chatStoredHistory,
+	)
+}
+
+func (sr *SqliteGameRepository) ChatHistory(channel string, before int64, limit int) []*ChatEntry {
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+	rows, err := sr.db.Query(
+		`SELECT seq, sender_uuid, sender_account_id, username, text, sent_at FROM chat_messages
+		WHERE channel = ? AND seq < ? ORDER BY seq DESC LIMIT ?`,
+		channel, before, limit,
+	)
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	var entries []*ChatEntry
+	for rows.Next() {
+		entry := &ChatEntry{channel: channel}
+		var sentAt int64
+		err = rows.Scan(&entry.seq, &entry.senderUUID, &entry.senderAccountID, &entry.username, &entry.text, &sentAt)
+		if err != nil {
+			log.Printf("sqlite error: %v", err)
+			return nil
+		}
+		entry.sentAt = time.Unix(0, sentAt)
+		entries = append(entries, entry)
+	}
+	// oldest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// AccountByID loads accounts from the database on first use
+func (sr *SqliteGameRepository) AccountByID(id string) *Account {
+	account := sr.GameRepository.AccountByID(id)
+	if account != nil {
+		return account
+	}
+	return sr.loadAccount(`id = ?`, id)
+}
+
+func (sr *SqliteGameRepository) AccountByUsername(username string) *Account {
+	account := sr.GameRepository.AccountByUsername(username)
+	if account != nil {
+		return account
+	}
+	return sr.loadAccount(`username_key = ?`, strings.ToLower(username))
+}
+
+// loadAccount reads the account matching the condition and keeps it in memory
+func (sr *SqliteGameRepository) loadAccount(condition string, args ...interface{}) *Account {
+	account := &Account{}
+	var createdAt, mutedUntil int64
+	var blocked string
+	err := sr.db.QueryRow(`SELECT id, username, rating, password_hash, created_at, muted_until, blocked FROM accounts WHERE `+condition, args...).Scan(
+		&account.id, &account.username, &account.rating, &account.passwordHash, &createdAt, &mutedUntil, &blocked)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	account.createdAt = time.Unix(0, createdAt)
+	if mutedUntil > 0 {
+		account.mutedUntil = time.Unix(0, mutedUntil)
+	}
+	account.blocked = strings.Fields(blocked)
+	sr.GameRepository.SaveAccount(account)
+	return account
+}
+
+func (sr *SqliteGameRepository) SaveAccount(account *Account) {
+	sr.GameRepository.SaveAccount(account)
+	var mutedUntil int64
+	if !account.mutedUntil.IsZero() {
+		mutedUntil = account.mutedUntil.UnixNano()
+	}
+	sr.exec(
+		`INSERT OR REPLACE INTO accounts (id, username, username_key, rating, password_hash, created_at, muted_until, blocked) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		account.id, account.username, strings.ToLower(account.username), account.rating, account.passwordHash, account.createdAt.UnixNano(),
+		mutedUntil, strings.Join(account.blocked, " "),
+	)
+}
+
+// StatsByAccount loads stats from the database on first use
+func (sr *SqliteGameRepository) StatsByAccount(accountID string) *PlayerStats {
+	stats := sr.GameRepository.StatsByAccount(accountID)
+	if stats != nil {
+		return stats
+	}
+	stats = &PlayerStats{accountID: accountID}
+	var totalDuration int64
+	err := sr.db.QueryRow(
+		`SELECT wins, losses, draws, streak, best_streak, cross_games, zero_games, total_duration FROM stats WHERE account_id = ?`,
+		accountID,
+	).Scan(&stats.wins, &stats.losses, &stats.draws, &stats.streak, &stats.bestStreak, &stats.crossGames, &stats.zeroGames, &totalDuration)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	stats.totalDuration = time.Duration(totalDuration) * time.Millisecond
+	sr.GameRepository.SaveStats(stats)
+	return stats
+}
+
+func (sr *SqliteGameRepository) SaveStats(stats *PlayerStats) {
+	sr.GameRepository.SaveStats(stats)
+	sr.exec(
+		`INSERT OR REPLACE INTO stats (account_id, wins, losses, draws, streak, best_streak, cross_games, zero_games, total_duration)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stats.accountID, stats.wins, stats.losses, stats.draws, stats.streak, stats.bestStreak,
+		stats.crossGames, stats.zeroGames, durationMillis(stats.totalDuration),
+	)
+}
+
+func (sr *SqliteGameRepository) AddGameResult(result *GameResult) {
+	sr.exec(
+		`INSERT INTO game_results (account_id, outcome, rating, finished_at) VALUES (?, ?, ?, ?)`,
+		result.accountID, result.outcome, result.rating, result.finishedAt.UnixNano(),
+	)
+}
+
+// Standings reads the database, results aren't kept in memory. The rating
+// is the one of the last result of the account, ties go to the later row.
+func (sr *SqliteGameRepository) Standings(since time.Time) []*Standing {
+	var start int64
+	if !since.IsZero() {
+		start = since.UnixNano()
+	}
+	rows, err := sr.db.Query(
+		`SELECT account_id, SUM(outcome = ?), MAX(CASE WHEN outcome = ? THEN finished_at ELSE 0 END), MAX(finished_at),
+			(SELECT rating FROM game_results last WHERE last.account_id = results.account_id AND last.finished_at >= ?
+			ORDER BY last.finished_at DESC, last.seq DESC LIMIT 1)
+		FROM game_results results WHERE finished_at >= ? GROUP BY account_id`,
+		outcomeWin, outcomeWin, start, start,
+	)
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	standings := []*Standing{}
+	for rows.Next() {
+		standing := &Standing{}
+		var wonAt, ratedAt int64
+		err = rows.Scan(&standing.accountID, &standing.wins, &wonAt, &ratedAt, &standing.rating)
+		if err != nil {
+			log.Printf("sqlite error: %v", err)
+			return nil
+		}
+		if standing.wins > 0 {
+			standing.wonAt = time.Unix(0, wonAt)
+		}
+		standing.ratedAt = time.Unix(0, ratedAt)
+		standings = append(standings, standing)
+	}
+	return standings
+}
+
+// Friendships reads the database, friendships aren't kept in memory
+func (sr *SqliteGameRepository) Friendships(accountID string) []*Friendship {
+	rows, err := sr.db.Query(
+		`SELECT requester_id, addressee_id, accepted, created_at FROM friendships WHERE requester_id = ? OR addressee_id = ?`,
+		accountID, accountID,
+	)
+	if err != nil {
+		log.Printf("sqlite error: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	friendships := []*Friendship{}
+	for rows.Next() {
+		friendship := &Friendship{}
+		var createdAt int64
+		err = rows.Scan(&friendship.requesterID, &friendship.addresseeID, &friendship.accepted, &createdAt)
+		if err != nil {
+			log.Printf("sqlite error: %v", err)
+			return nil
+		}
+		friendship.createdAt = time.Unix(0, createdAt)
+		friendships = append(friendships, friendship)
+	}
+	return friendships
+}
+
+func (sr *SqliteGameRepository) SaveFriendship(friendship *Friendship) {
+	sr.exec(
+		`INSERT OR REPLACE INTO friendships (requester_id, addressee_id, accepted, created_at) VALUES (?, ?, ?, ?)`,
+		friendship.requesterID, friendship.addresseeID, friendship.accepted, friendship.createdAt.UnixNano(),
+	)
+}
+
+func (sr *SqliteGameRepository) RemoveFriendship(friendship *Friendship) {
+	sr.exec(
+		`DELETE FROM friendships WHERE requester_id = ? AND addressee_id = ?`,
+		friendship.requesterID, friendship.addresseeID,
+	)
+}
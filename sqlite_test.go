@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func MockSqliteRepository(t *testing.T) (*SqliteGameRepository, func()) {
+	dir, err := ioutil.TempDir("", "mobile-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository, err := SqliteRepository(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	sr := repository.(*SqliteGameRepository)
+	return sr, func() {
+		sr.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func reopenSqliteRepository(t *testing.T, sr *SqliteGameRepository) *SqliteGameRepository {
+	var path string
+	err := sr.db.QueryRow(`SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr.Close()
+	repository, err := SqliteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repository.(*SqliteGameRepository)
+}
+
+func TestSqliteGameRepository_Restart(t *testing.T) {
+	sr, cleanup := MockSqliteRepository(t)
+	defer cleanup()
+
+	mockCrossUser, mockZeroUser, mockSearchUser := MockUser(), MockUser(), MockUser()
+	mockGame := MockGame(mockCrossUser, mockZeroUser)
+	mockCrossUser.currentGameUUID = mockGame.uuid
+	mockCrossUser.rating = 1300
+	mockCrossUser.blocked[mockZeroUser.uuid] = true
+	mockCrossUser.accountID = "account"
+	mockAccount := &Account{"account", mockCrossUser.username, 1300, "hash", fromUnixMillis(1500000000000), fromUnixMillis(1600000000000), []string{"blocked", "other"}}
+	sr.SaveAccount(mockAccount)
+	mockStats := &PlayerStats{"account", 3, 1, 1, 2, 2, 3, 2, 5 * time.Minute}
+	sr.SaveStats(mockStats)
+	mockResult := &GameResult{"account", outcomeWin, 1300, fromUnixMillis(1500000000000)}
+	sr.AddGameResult(&GameResult{"account", outcomeLoss, 1284, fromUnixMillis(1400000000000)})
+	sr.AddGameResult(mockResult)
+	mockFriendship := &Friendship{"account", "friend", true, fromUnixMillis(1500000000000)}
+	sr.SaveFriendship(mockFriendship)
+	sr.SaveFriendship(&Friendship{"stranger", "friend", false, fromUnixMillis(1500000000000)})
+	mockCrossUser.mutedUntil = time.Now().Add(time.Hour)
+	mockZeroUser.currentGameUUID = mockGame.uuid
+	for _, user := range []*User{mockCrossUser, mockZeroUser, mockSearchUser} {
+		sr.AddUser(user)
+	}
+	mockSearchUser.searchVariant.timeControl = TimeControl{time.Minute, time.Second, 0}
+	sr.AddUserInSearch(mockSearchUser)
+	sr.AddGame(mockGame)
+
+	mockGame.field = GameField{1: CROSS, 2: EMPTY, 3: ZERO}
+	mockGame.currentMoveUnit = ZERO
+	mockGame.moves = []MoveRecord{
+		{CROSS, mockCrossUser.uuid, 1, mockGame.startedAt.Add(time.Second)},
+		{ZERO, mockZeroUser.uuid, 3, mockGame.startedAt.Add(2 * time.Second)},
+	}
+	sr.SaveGame(mockGame)
+	mockReplay := &Replay{generateUUID(), DefaultGameVariant, mockCrossUser.uuid, mockZeroUser.uuid, resultDraw, fromUnixMillis(1500000000000), nil}
+	sr.SaveReplay(mockReplay)
+	mockInvite := NewInvite(mockSearchUser, mockSearchUser.searchVariant, fromUnixMillis(1500000000000))
+	sr.AddInvite(mockInvite)
+	mockEntry := &ChatEntry{0, "room:gophers", mockCrossUser.uuid, "", mockCrossUser.username, "hi", fromUnixMillis(1500000000000)}
+	sr.AddChatEntry(&ChatEntry{0, "room:gophers", mockZeroUser.uuid, "", mockZeroUser.username, "hello", fromUnixMillis(1500000000000)})
+	sr.AddChatEntry(mockEntry)
+
+	sr = reopenSqliteRepository(t, sr)
+	defer sr.Close()
+
+	user := sr.UserByUUID(mockCrossUser.uuid)
+	if user == nil || user.username != mockCrossUser.username || user.currentGameUUID != mockGame.uuid || user.rating != 1300 {
+		t.Fatalf("user wasn't restored: %v", user)
+	}
+	if !user.blocks(mockZeroUser) || !user.mutedUntil.Equal(mockCrossUser.mutedUntil) {
+		t.Errorf("chat moderation wasn't restored: %v %v", user.blocked, user.mutedUntil)
+	}
+	if account := sr.AccountByID(user.accountID); !reflect.DeepEqual(account, mockAccount) {
+		t.Errorf("account = %v, want %v", account, mockAccount)
+	}
+	if stats := sr.StatsByAccount("account"); !reflect.DeepEqual(stats, mockStats) {
+		t.Errorf("stats = %v, want %v", stats, mockStats)
+	}
+	mockStanding := &Standing{"account", 1, mockResult.finishedAt, 1300, mockResult.finishedAt}
+	if standings := sr.Standings(fromUnixMillis(1500000000000)); len(standings) != 1 || !reflect.DeepEqual(standings[0], mockStanding) {
+		t.Errorf("standings = %v, want %v", standings, mockStanding)
+	}
+	if standings := sr.Standings(fromUnixMillis(1600000000000)); len(standings) != 0 {
+		t.Errorf("later standings = %v, want none", standings)
+	}
+	if standings := sr.Standings(time.Time{}); len(standings) != 1 || !reflect.DeepEqual(standings[0], mockStanding) {
+		t.Errorf("all standings = %v, want %v", standings, mockStanding)
+	}
+	if friendships := sr.Friendships("account"); len(friendships) != 1 || !reflect.DeepEqual(friendships[0], mockFriendship) {
+		t.Errorf("friendships = %v, want %v", friendships, mockFriendship)
+	}
+	if friendships := sr.Friendships("friend"); len(friendships) != 2 {
+		t.Errorf("friendships of the addressee = %v, want 2", friendships)
+	}
+	inSearch := sr.UsersInSearchInsertionOrder()
+	if len(inSearch) != 1 || inSearch[0].uuid != mockSearchUser.uuid || inSearch[0].searchVariant != mockSearchUser.searchVariant {
+		t.Errorf("search queue wasn't restored: %v", inSearch)
+	}
+	game := sr.GameByUUID(mockGame.uuid)
+	if game == nil {
+		t.Fatal("game wasn't restored")
+	}
+	if !reflect.DeepEqual(game.field, mockGame.field) {
+		t.Errorf("game field = %v, want %v", game.field, mockGame.field)
+	}
+	if game.currentMoveUnit != ZERO || game.isOver {
+		t.Errorf("game state = %v %v, want %v %v", game.currentMoveUnit, game.isOver, ZERO, false)
+	}
+	if game.crossUser != user || game.zeroUser.uuid != mockZeroUser.uuid {
+		t.Errorf("game players weren't restored")
+	}
+	if !game.startedAt.Equal(mockGame.startedAt) ||
+		formatMoves(game.startedAt, game.moves) != formatMoves(mockGame.startedAt, mockGame.moves) {
+		t.Errorf("game moves = %v, want %v", game.moves, mockGame.moves)
+	}
+	if replay := sr.ReplayByUUID(mockReplay.gameUUID); !reflect.DeepEqual(replay, mockReplay) {
+		t.Errorf("replay = %v, want %v", replay, mockReplay)
+	}
+	if invite := sr.InviteByCode(mockInvite.code); !reflect.DeepEqual(invite, mockInvite) {
+		t.Errorf("invite = %v, want %v", invite, mockInvite)
+	}
+	if history := sr.ChatHistory("room:gophers", 0, 1); len(history) != 1 || !reflect.DeepEqual(history[0], mockEntry) {
+		t.Errorf("chat history = %v, want %v", history, mockEntry)
+	}
+	if history := sr.ChatHistory("room:gophers", mockEntry.seq, 10); len(history) != 1 || history[0].text != "hello" {
+		t.Errorf("chat history before %v = %v", mockEntry.seq, history)
+	}
+}
+
+func TestSqliteRepository_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mobile-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+
+	// database created before any migration
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(sqliteSchema)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := SqliteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := repository.(*SqliteGameRepository)
+	defer sr.Close()
+	var version int
+	err = sr.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil || version != len(sqliteMigrations) {
+		t.Errorf("user_version = %v, want %v: %v", version, len(sqliteMigrations), err)
+	}
+}
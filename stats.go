@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PlayerStats are the results of the decided games of an account, streak
+// is the current run of wins
+type PlayerStats struct {
+	accountID     string
+	wins          int
+	losses        int
+	draws         int
+	streak        int
+	bestStreak    int
+	crossGames    int
+	zeroGames     int
+	totalDuration time.Duration
+}
+
+// statsMutex serializes updates of the stats, games of an account may end
+// at once on several devices
+var statsMutex = &sync.Mutex{}
+
+func (s *PlayerStats) games() int {
+	return s.wins + s.losses + s.draws
+}
+
+// outcomes of a decided game for a player
+const (
+	outcomeWin  = "win"
+	outcomeLoss = "loss"
+	outcomeDraw = "draw"
+)
+
+// outcomeOf returns the outcome of the game with result for unit
+func outcomeOf(unit GameUnit, result string) string {
+	switch result {
+	case resultDraw:
+		return outcomeDraw
+	case resultOf(unit):
+		return outcomeWin
+	}
+	return outcomeLoss
+}
+
+// add counts a game played as unit which ended with result
+func (s *PlayerStats) add(unit GameUnit, result string, duration time.Duration) {
+	switch outcomeOf(unit, result) {
+	case outcomeDraw:
+		s.draws++
+		s.streak = 0
+	case outcomeWin:
+		s.wins++
+		s.streak++
+		if s.streak > s.bestStreak {
+			s.bestStreak = s.streak
+		}
+	default:
+		s.losses++
+		s.streak = 0
+	}
+	if unit == CROSS {
+		s.crossGames++
+	} else {
+		s.zeroGames++
+	}
+	s.totalDuration += duration
+}
+
+// recordStats adds the finished game to the stats and the results of the
+// players with accounts. Like ratings, aborted and unrated games don't
+// count.
+func (g *Game) recordStats(result string) {
+	if result == resultAborted || !g.rated() {
+		return
+	}
+	now := g.clock.Now()
+	duration := now.Sub(g.startedAt)
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	for _, player := range g.users {
+		accountID := player.account()
+		if accountID == "" {
+			continue
+		}
+		stats := PlayerStats{accountID: accountID}
+		if stored := g.repository.StatsByAccount(accountID); stored != nil {
+			stats = *stored
+		}
+		unit := g.unitOf(player)
+		stats.add(unit, result, duration)
+		g.repository.SaveStats(&stats)
+		g.repository.AddGameResult(&GameResult{accountID, outcomeOf(unit, result), player.currentRating(), now})
+	}
+}
+
+// profile describes an account and its stats, averageGameLength is in
+// milliseconds
+func profile(repository IRepository, account *Account) map[string]string {
+	stats := repository.StatsByAccount(account.id)
+	if stats == nil {
+		stats = &PlayerStats{accountID: account.id}
+	}
+	average := time.Duration(0)
+	if stats.games() > 0 {
+		average = stats.totalDuration / time.Duration(stats.games())
+	}
+	return map[string]string{
+		"accountID":         account.id,
+		"username":          account.username,
+		"rating":            strconv.Itoa(account.rating),
+		"games":             strconv.Itoa(stats.games()),
+		"wins":              strconv.Itoa(stats.wins),
+		"losses":            strconv.Itoa(stats.losses),
+		"draws":             strconv.Itoa(stats.draws),
+		"winStreak":         strconv.Itoa(stats.streak),
+		"bestWinStreak":     strconv.Itoa(stats.bestStreak),
+		"crossGames":        strconv.Itoa(stats.crossGames),
+		"zeroGames":         strconv.Itoa(stats.zeroGames),
+		"averageGameLength": milliseconds(average),
+		"createdAt":         account.createdAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// profileAccount finds the account of a profile request by "accountID" or
+// "username", the account of the user itself without them
+func profileAccount(repository IRepository, user *User, query map[string]string) *Account {
+	if accountID := query["accountID"]; accountID != "" {
+		return repository.AccountByID(accountID)
+	}
+	if username := query["username"]; username != "" {
+		return repository.AccountByUsername(username)
+	}
+	if user == nil || user.account() == "" {
+		return nil
+	}
+	return repository.AccountByID(user.account())
+}
+
+// handleProfile serves the profile of the account given by the accountID
+// or the username query parameter as JSON
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := map[string]string{
+		"accountID": r.URL.Query().Get("accountID"),
+		"username":  r.URL.Query().Get("username"),
+	}
+	account := profileAccount(Repository, nil, query)
+	if account == nil {
+		http.Error(w, "account not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile(Repository, account))
+}
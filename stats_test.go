@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlayerStats_add(t *testing.T) {
+	stats := &PlayerStats{accountID: "account"}
+	for _, game := range []struct {
+		unit   GameUnit
+		result string
+	}{
+		{CROSS, resultCrossWins},
+		{ZERO, resultZeroWins},
+		{CROSS, resultDraw},
+		{ZERO, resultZeroWins},
+		{ZERO, resultCrossWins},
+	} {
+		stats.add(game.unit, game.result, time.Minute)
+	}
+	want := PlayerStats{"account", 3, 1, 1, 0, 2, 2, 3, 5 * time.Minute}
+	if *stats != want {
+		t.Errorf("PlayerStats.add() = %v, want %v", *stats, want)
+	}
+}
+
+func TestGame_Stats(t *testing.T) {
+	game, clock := startClockGame(t, TimeControl{})
+	repository := game.repository
+	for _, user := range game.users {
+		user.mutex.Lock()
+		user.accountID = user.uuid
+		user.mutex.Unlock()
+		repository.SaveAccount(&Account{user.uuid, user.username, defaultRating, "", time.Now(), time.Time{}, nil})
+	}
+
+	clock.Advance(30 * time.Second)
+	game.Resign(game.crossUser)
+	for _, user := range game.users {
+		<-user.writeChan
+	}
+
+	standings := map[string]*Standing{}
+	for _, standing := range repository.Standings(time.Time{}) {
+		standings[standing.accountID] = standing
+	}
+	if winner := standings[game.zeroUser.uuid]; len(standings) != 2 || winner == nil || winner.wins != 1 || winner.rating != 1216 {
+		t.Errorf("standings = %v", standings)
+	}
+
+	Repository = repository
+	recorder := httptest.NewRecorder()
+	handleProfile(recorder, httptest.NewRequest("GET", "/profile?accountID="+game.zeroUser.uuid, nil))
+	var got map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("GET /profile = %v %v: %v", recorder.Code, recorder.Body, err)
+	}
+	for key, want := range map[string]string{
+		"username":          game.zeroUser.username,
+		"rating":            "1216",
+		"games":             "1",
+		"wins":              "1",
+		"winStreak":         "1",
+		"zeroGames":         "1",
+		"averageGameLength": "30000",
+	} {
+		if got[key] != want {
+			t.Errorf("profile %v = %v, want %v", key, got[key], want)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	handleProfile(recorder, httptest.NewRequest("GET", "/profile?username=nobody", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("GET /profile of an unknown user = %v, want %v", recorder.Code, http.StatusNotFound)
+	}
+
+	game.crossUser.resolveMessage(Message{ID: "profile", Type: ProfileGet})
+	if gotMsg := <-game.crossUser.writeChan; gotMsg.ID != "profile" || gotMsg.Type != ProfileResult ||
+		gotMsg.Payload["losses"] != "1" || gotMsg.Payload["crossGames"] != "1" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	guest := MockUserWithRepository(repository)
+	guest.resolveMessage(Message{Type: ProfileGet})
+	if gotMsg := <-guest.writeChan; gotMsg.Type != Error || gotMsg.Payload["code"] != "ACCOUNT_NOT_FOUND" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+}
+
+func TestHandleProfile(t *testing.T) {
+	Repository = InmemoryRepository()
+	createdAt := time.Date(2017, 7, 14, 15, 0, 0, 0, time.UTC)
+	Repository.SaveAccount(&Account{"account", "alice", 1216, "", createdAt, time.Time{}, nil})
+	Repository.SaveStats(&PlayerStats{"account", 2, 1, 1, 0, 2, 3, 1, 2 * time.Minute})
+
+	recorder := httptest.NewRecorder()
+	handleProfile(recorder, httptest.NewRequest("GET", "/profile?username=alice", nil))
+	var got map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("GET /profile = %v %v: %v", recorder.Code, recorder.Body, err)
+	}
+	want := map[string]string{
+		"accountID":         "account",
+		"username":          "alice",
+		"rating":            "1216",
+		"games":             "4",
+		"wins":              "2",
+		"losses":            "1",
+		"draws":             "1",
+		"winStreak":         "0",
+		"bestWinStreak":     "2",
+		"crossGames":        "3",
+		"zeroGames":         "1",
+		"averageGameLength": "30000",
+		"createdAt":         "2017-07-14T15:00:00Z",
+	}
+	if !reflect.DeepEqual(got, want) || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("GET /profile = %v, want %v", got, want)
+	}
+
+	recorder = httptest.NewRecorder()
+	handleProfile(recorder, httptest.NewRequest("GET", "/profile?accountID=unknown", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("GET /profile of an unknown account = %v, want %v", recorder.Code, http.StatusNotFound)
+	}
+	recorder = httptest.NewRecorder()
+	handleProfile(recorder, httptest.NewRequest("POST", "/profile?accountID=account", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /profile = %v, want %v", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestGame_Abandoned(t *testing.T) {
+	game, _ := startClockGame(t, TimeControl{})
+	repository := game.repository
+	for _, user := range game.users {
+		user.mutex.Lock()
+		user.accountID = user.uuid
+		user.mutex.Unlock()
+		repository.SaveAccount(&Account{user.uuid, user.username, defaultRating, "", time.Now(), time.Time{}, nil})
+	}
+	if err := game.Move(game.crossUser, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range game.users {
+		<-user.writeChan
+	}
+
+	// the grace period of the zero player expired
+	go game.GameOver(game.zeroUser)
+	gotMsg := <-game.crossUser.writeChan
+	<-game.zeroUser.writeChan
+	if gotMsg.Type != GameWinner || gotMsg.Payload["winner"] != string(CROSS) || gotMsg.Payload["reason"] != "abandoned" {
+		t.Errorf("invalid write message %v", gotMsg)
+	}
+	if stats := repository.StatsByAccount(game.zeroUser.uuid); stats == nil || stats.losses != 1 {
+		t.Errorf("stats of the player who left = %v", stats)
+	}
+	if rating := game.crossUser.currentRating(); rating != 1216 {
+		t.Errorf("rating of the winner = %v, want 1216", rating)
+	}
+}
+
+func TestGame_SameAccount(t *testing.T) {
+	game, _ := startClockGame(t, TimeControl{})
+	repository := game.repository
+	for _, user := range game.users {
+		user.mutex.Lock()
+		user.accountID = "account"
+		user.mutex.Unlock()
+	}
+	repository.SaveAccount(&Account{"account", game.crossUser.username, defaultRating, "", time.Now(), time.Time{}, nil})
+
+	game.Resign(game.crossUser)
+	for _, user := range game.users {
+		<-user.writeChan
+	}
+	if stats := repository.StatsByAccount("account"); stats != nil {
+		t.Errorf("stats of a game between sessions of an account = %v", stats)
+	}
+	if standings := repository.Standings(time.Time{}); len(standings) != 0 {
+		t.Errorf("standings = %v", standings)
+	}
+	if rating := game.zeroUser.currentRating(); rating != defaultRating {
+		t.Errorf("rating = %v, want %v", rating, defaultRating)
+	}
+}
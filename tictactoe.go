@@ -0,0 +1,22 @@
+package main
+
+// TicTacToeRules is an m,n,k game: units are placed on any empty cell.
+type TicTacToeRules struct {
+	board
+}
+
+func (r *TicTacToeRules) ValidateMove(field GameField, unit GameUnit, position int) error {
+	value, ok := field[position]
+	if !ok {
+		return errInvalidPosition
+	}
+	if value != EMPTY {
+		return errCellOccupied
+	}
+	return nil
+}
+
+func (r *TicTacToeRules) ApplyMove(field GameField, unit GameUnit, position int) int {
+	field[position] = unit
+	return position
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/gorilla/websocket"
-	"html"
 	"log"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type User struct {
@@ -14,68 +18,376 @@ type User struct {
 	ws              *websocket.Conn
 	writeChan       chan Message
 	repository      IRepository
+	searchVariant   GameVariant
+	rating          int
+	searchStartedAt time.Time
+	reconnectToken  string
+	// lastGameUUID is the finished game a rematch is played against,
+	// rematchGameUUID is set while the user waits for the rematch of it
+	lastGameUUID    string
+	rematchGameUUID string
+	// watchingGameUUID is the game the user is a spectator of
+	watchingGameUUID string
+	// rooms are the named chat rooms the user is in
+	rooms map[string]bool
+	// chatSentAt are the times of the recent messages of the user, see
+	// allowChat, blocked are the users whose messages it doesn't receive
+	chatSentAt []time.Time
+	blocked    map[string]bool
+	mutedUntil time.Time
+	// accountID is the account of a user logged in with a token, claims
+	// are the claims of the token the socket connected with
+	accountID string
+	claims    *Claims
+	// bot is set for server side players, see Bot
+	bot bool
+	// detached is set while the user waits for a reconnect without a socket
+	detached    bool
+	detachTimer *time.Timer
+	// quit stops the write loop of the current socket, done is closed
+	// once it stopped
+	quit  chan struct{}
+	done  chan struct{}
+	mutex *sync.Mutex
 }
 
-func (u *User) readLoop() {
+// writeBufferSize is the capacity of the queue of messages to a user
+var writeBufferSize = 2
+
+// writeTimeout bounds writing a message to the socket and waiting for room
+// in the queue, clients slower than that are disconnected
+var writeTimeout = 10 * time.Second
+
+func NewUser(repository IRepository) *User {
+	return &User{
+		generateUUID(),
+		"<empty>",
+		"",
+		nil,
+		make(chan Message, writeBufferSize),
+		repository,
+		DefaultGameVariant,
+		defaultRating,
+		time.Time{},
+		"",
+		"",
+		"",
+		"",
+		map[string]bool{},
+		nil,
+		map[string]bool{},
+		time.Time{},
+		"",
+		nil,
+		false,
+		false,
+		nil,
+		nil,
+		nil,
+		&sync.Mutex{},
+	}
+}
+
+// the accessors below guard the fields shared between the user's own
+// goroutines, game goroutines and the daemons
+
+func (u *User) gameUUID() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.currentGameUUID
+}
+
+func (u *User) setGameUUID(uuid string) {
+	u.mutex.Lock()
+	u.currentGameUUID = uuid
+	u.mutex.Unlock()
+}
+
+func (u *User) name() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.username
+}
+
+func (u *User) setName(username string) {
+	u.mutex.Lock()
+	u.username = username
+	u.mutex.Unlock()
+}
+
+func (u *User) currentRating() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.rating
+}
+
+func (u *User) setRating(rating int) {
+	u.mutex.Lock()
+	u.rating = rating
+	u.mutex.Unlock()
+}
+
+// search returns the variant the user is searching for and since when
+func (u *User) search() (GameVariant, time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.searchVariant, u.searchStartedAt
+}
+
+func (u *User) setSearch(variant GameVariant, startedAt time.Time) {
+	u.mutex.Lock()
+	u.searchVariant = variant
+	u.searchStartedAt = startedAt
+	u.mutex.Unlock()
+}
+
+func (u *User) lastGame() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.lastGameUUID
+}
+
+// setLastGame remembers the finished game and forgets the rematch request
+// of the previous one
+func (u *User) setLastGame(uuid string) {
+	u.mutex.Lock()
+	u.lastGameUUID = uuid
+	u.rematchGameUUID = ""
+	u.mutex.Unlock()
+}
+
+func (u *User) setRematch(gameUUID string) {
+	u.mutex.Lock()
+	u.rematchGameUUID = gameUUID
+	u.mutex.Unlock()
+}
+
+// takeRematch consumes the rematch request of gameUUID, false when the
+// user didn't ask for it
+func (u *User) takeRematch(gameUUID string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.rematchGameUUID == "" || u.rematchGameUUID != gameUUID {
+		return false
+	}
+	u.rematchGameUUID = ""
+	return true
+}
+
+func (u *User) readLoop(ws *websocket.Conn) {
 	for {
 		var message Message
-		err := u.ws.ReadJSON(&message)
+		err := ws.ReadJSON(&message)
 		log.Printf("message read: %v", message)
 		if err != nil {
 			log.Printf("error: %v", err)
 			break
 		}
 		u.resolveMessage(message)
+		if u.connection() != ws {
+			// the socket was handed over to another user by Resume
+			return
+		}
 	}
-	u.close()
-	err := u.ws.Close()
+	err := ws.Close()
 	if err != nil {
 		log.Printf("error: %v", err)
 	}
+	u.disconnect(ws)
 }
 
-func (u *User) writeLoop() {
+func (u *User) writeLoop(ws *websocket.Conn, quit, done chan struct{}) {
+	defer close(done)
 	for {
-		message := <-u.writeChan
-		log.Printf("message sended: %v", message)
-		err := u.ws.WriteJSON(message)
-		if err != nil {
-			u.close()
-			log.Printf("error: %v", err)
-			break
+		select {
+		case <-quit:
+			return
+		case message := <-u.writeChan:
+			log.Printf("message sended: %v", message)
+			ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err := ws.WriteJSON(message)
+			if err != nil {
+				log.Printf("error: %v", err)
+				// read loop fails on the closed socket and disconnects the user
+				ws.Close()
+				return
+			}
+		}
+	}
+}
+
+// send queues the message for the user, messages to detached users are
+// dropped: the state is replayed on Resume instead. A user whose queue
+// stays full for writeTimeout loses the message and the socket, so games
+// and daemons never wait for a client that stopped reading.
+func (u *User) send(message Message) {
+	u.mutex.Lock()
+	detached, ws := u.detached, u.ws
+	u.mutex.Unlock()
+	if detached {
+		log.Printf("message dropped for detached user %v: %v", u.uuid, message)
+		return
+	}
+	select {
+	case u.writeChan <- message:
+		return
+	default:
+	}
+	timer := time.NewTimer(writeTimeout)
+	defer timer.Stop()
+	select {
+	case u.writeChan <- message:
+	case <-timer.C:
+		log.Printf("message dropped for slow user %v: %v", u.uuid, message)
+		if ws != nil {
+			// read loop fails on the closed socket and disconnects the user
+			ws.Close()
 		}
 	}
 }
 
 func (u *User) close() {
+	u.leaveRooms()
+	u.unwatch()
 	u.repository.RemoveUserInSearch(u)
+	u.dropChallenges()
 	u.repository.RemoveUser(u)
-	if u.currentGameUUID != "" {
-		game := u.repository.GameByUUID(u.currentGameUUID)
-		if game != nil {
-			game.GameOver()
-		}
+	u.announcePresence()
+	game := u.repository.GameByUUID(u.gameUUID())
+	if game != nil {
+		game.GameOver(u)
 	}
 }
 
+// resolveMessage handles a client message and reports a rejection back
 func (u *User) resolveMessage(message Message) {
+	err := u.handleMessage(message)
+	if err != nil {
+		log.Printf("message rejected: %v", err)
+		u.send(errorMessage(message, err))
+		return
+	}
+	if message.ID != "" && acknowledged[message.Type] {
+		u.send(Message{
+			ID:      message.ID,
+			Type:    Ack,
+			Payload: map[string]string{"requestType": message.Type},
+		})
+	}
+}
+
+func (u *User) handleMessage(message Message) error {
 	switch message.Type {
 	case Login:
-		u.username = message.Payload["username"]
-		u.repository.AddUser(u)
-		u.writeChan <- Message{
-			LoginSuccess,
-			map[string]string{
-				"uuid":     u.uuid,
-				"username": u.username,
-			},
+		err := u.login(message.Payload)
+		if err != nil {
+			protocolError := err.(*ProtocolError)
+			u.send(Message{
+				ID:   message.ID,
+				Type: LoginFailed,
+				Payload: map[string]string{
+					"reason":  protocolError.Code,
+					"message": protocolError.Text,
+				},
+			})
+			return nil
 		}
+		_, withPassword := message.Payload["password"]
+		u.send(Message{
+			ID:      message.ID,
+			Type:    LoginSuccess,
+			Payload: u.loginPayload(withPassword),
+		})
+		u.announcePresence()
+
+	case Register:
+		_, err := u.register(message.Payload["username"], message.Payload["password"])
+		if err != nil {
+			return err
+		}
+		u.send(Message{
+			ID:      message.ID,
+			Type:    RegisterSuccess,
+			Payload: u.loginPayload(true),
+		})
+		u.announcePresence()
+
+	case Rename:
+		err := u.rename(message.Payload["username"])
+		if err != nil {
+			return err
+		}
+		u.send(Message{
+			ID:      message.ID,
+			Type:    RenameSuccess,
+			Payload: map[string]string{"username": u.name()},
+		})
+
+	case ProfileGet:
+		account := profileAccount(u.repository, u, message.Payload)
+		if account == nil {
+			return errAccountNotFound
+		}
+		u.send(Message{
+			ID:      message.ID,
+			Type:    ProfileResult,
+			Payload: profile(u.repository, account),
+		})
+
+	case LeaderboardGet:
+		return u.leaderboard(message)
+
+	case FriendRequest:
+		return u.friendRequest(message.Payload)
+
+	case FriendAccept, FriendDecline:
+		return u.friendAnswer(message.Payload, message.Type == FriendAccept)
+
+	case FriendRemove:
+		return u.friendRemove(message.Payload)
+
+	case FriendList:
+		return u.friendList(message)
+
+	case Resume:
+		target := userByReconnectToken(u.repository, message.Payload["token"])
+		if target == nil || target == u {
+			return errInvalidToken
+		}
+		u.handOver(target, message.ID)
 
 	case GameSearchOn:
+		if u.gameUUID() != "" {
+			return errAlreadyInGame
+		}
+		variant, ok := ParseGameVariant(message.Payload)
+		if !ok {
+			return errInvalidVariant
+		}
+		_, startedAt := u.search()
+		if _, ok := u.repository.UsersInSearch()[u.uuid]; !ok {
+			startedAt = time.Now()
+		}
+		u.setSearch(variant, startedAt)
 		u.repository.AddUserInSearch(u)
+		wait, ok := expectedWait(u, u.repository.UsersInSearchInsertionOrder(), time.Now())
+		expected := "-1"
+		if ok {
+			expected = strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		}
+		u.send(Message{
+			ID:   message.ID,
+			Type: GameSearchWait,
+			Payload: map[string]string{
+				"expectedWait": expected,
+				"rating":       strconv.Itoa(u.currentRating()),
+			},
+		})
+		u.announcePresence()
 
 	case GameSearchOff:
 		u.repository.RemoveUserInSearch(u)
+		u.announcePresence()
 
 	case GameOver:
 		u.close()
@@ -83,77 +395,132 @@ func (u *User) resolveMessage(message Message) {
 	case GameMove:
 		position, err := strconv.Atoi(message.Payload["position"])
 		if err != nil {
-			return
+			return errInvalidPosition
 		}
-		game := u.repository.GameByUUID(u.currentGameUUID)
+		game := u.repository.GameByUUID(u.gameUUID())
 		if game == nil {
-			return
-		}
-		if game.isOver {
-			return
+			return errNoActiveGame
 		}
-		val, ok := game.field[position]
-		if !ok || val != EMPTY {
-			return
-		}
-		if (game.currentMoveUnit == CROSS) && (game.crossUser == u) {
-			log.Printf("CROSS MOVED: %v\n", position)
-			game.field[position] = CROSS
-			game.currentMoveUnit = ZERO
-		} else if (game.currentMoveUnit == ZERO) && (game.zeroUser == u) {
-			log.Printf("ZERO MOVED: %v\n", position)
-			game.field[position] = ZERO
-			game.currentMoveUnit = CROSS
-		}
-		message = Message{
-			GameMoved,
-			game.GetField(),
+		return game.Move(u, position)
+
+	case GameResign, DrawOffer, DrawAccept, DrawDecline:
+		game := u.repository.GameByUUID(u.gameUUID())
+		if game == nil {
+			return errNoActiveGame
 		}
-		for _, user := range game.users {
-			user.writeChan <- message
+		switch message.Type {
+		case GameResign:
+			return game.Resign(u)
+		case DrawOffer:
+			return game.OfferDraw(u)
+		default:
+			return game.AnswerDraw(u, message.Type == DrawAccept)
 		}
-		winner, ok := game.CheckWinner()
-		if ok {
-			message = Message{
-				GameWinner,
-				map[string]string{
-					"winner": string(winner),
-				},
-			}
-			for _, user := range game.users {
-				user.currentGameUUID = ""
-				user.writeChan <- message
-			}
-			return
+
+	case RematchRequest:
+		return u.requestRematch()
+
+	case RematchAccept:
+		return u.acceptRematch()
+
+	case GameCreatePrivate:
+		variant, ok := ParseGameVariant(message.Payload)
+		if !ok {
+			return errInvalidVariant
 		}
-		if game.CheckDraw() {
-			message = Message{
-				GameDraw,
-				map[string]string{},
-			}
-			for _, user := range game.users {
-				user.currentGameUUID = ""
-				user.writeChan <- message
-			}
+		invite, err := u.createPrivate(variant)
+		if err != nil {
+			return err
 		}
+		u.send(Message{
+			ID:   message.ID,
+			Type: GamePrivateCreated,
+			Payload: map[string]string{
+				"code":      invite.code,
+				"expiresAt": invite.expiresAt.UTC().Format(time.RFC3339),
+			},
+		})
 
-	case MessageSend:
-		text, ok := message.Payload["text"]
-		if !ok {
-			return
+	case GameJoinPrivate:
+		return u.joinPrivate(strings.ToUpper(strings.TrimSpace(message.Payload["code"])))
+
+	case ChallengeSend:
+		challenge, err := u.sendChallenge(message.Payload)
+		if err != nil {
+			return err
 		}
-		message := Message{
-			MessageNew,
-			map[string]string{
-				"text":     html.EscapeString(text),
-				"username": html.EscapeString(u.username),
+		u.send(Message{
+			ID:   message.ID,
+			Type: ChallengeSent,
+			Payload: map[string]string{
+				"challengeID": challenge.id,
+				"userUUID":    challenge.challengedUUID,
+				"expiresAt":   challenge.expiresAt.UTC().Format(time.RFC3339),
 			},
+		})
+
+	case ChallengeAccept:
+		return u.acceptChallenge(message.Payload["challengeID"])
+
+	case ChallengeDecline:
+		return u.declineChallenge(message.Payload["challengeID"])
+
+	case GameWatch:
+		return u.watch(message.Payload["gameUUID"], message.ID)
+
+	case GameUnwatch:
+		u.unwatch()
+
+	case GameList:
+		u.send(Message{
+			ID:      message.ID,
+			Type:    GameListResult,
+			Payload: map[string]string{"games": liveGames(u.repository)},
+		})
+
+	case GameReplay:
+		replay := u.repository.ReplayByUUID(message.Payload["gameUUID"])
+		if replay == nil {
+			return errReplayNotFound
 		}
-		for _, user := range u.repository.Users() {
-			if user.uuid == u.uuid {
-				continue
-			}
-			user.writeChan <- message
+		u.send(Message{
+			ID:      message.ID,
+			Type:    GameReplayResult,
+			Payload: replay.payload(),
+		})
+
+	case MessageSend:
+		return u.chatSend(message.Payload)
+
+	case ChatHistory:
+		return u.chatHistory(message)
+
+	case ChatJoin:
+		return u.chatJoin(message.Payload["room"])
+
+	case ChatLeave:
+		return u.chatLeave(message.Payload["room"])
+
+	case ChatBlock, ChatUnblock:
+		return u.setBlocked(message.Payload["userUUID"], message.Type == ChatBlock)
+
+	case ChatBlockList:
+		blocked, _ := json.Marshal(u.blockedUsers())
+		u.send(Message{
+			ID:      message.ID,
+			Type:    ChatBlockListResult,
+			Payload: map[string]string{"users": string(blocked)},
+		})
+
+	case ChatMute:
+		seconds, err := strconv.Atoi(message.Payload["duration"])
+		if err != nil {
+			return errInvalidPayload
 		}
+		return u.mute(message.Payload["key"], message.Payload["userUUID"], time.Duration(seconds)*time.Second)
+
+	default:
+		return errUnknownType
 	}
+	return nil
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// usernames are 3 to 20 letters, digits, dots, dashes and underscores
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 20
+)
+
+var usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}._-]+$`)
+
+// reservedUsernames can't be taken by players, compared in lower case
+var reservedUsernames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"moderator":     true,
+	"system":        true,
+	"server":        true,
+	"support":       true,
+	"root":          true,
+	"bot":           true,
+}
+
+// usernamesMutex makes the uniqueness check and taking the name atomic
+var usernamesMutex = &sync.Mutex{}
+
+// validateUsername checks the form of a username, not whether it's taken
+func validateUsername(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < minUsernameLength {
+		return errUsernameTooShort
+	}
+	if length > maxUsernameLength {
+		return errUsernameTooLong
+	}
+	if !usernamePattern.MatchString(username) {
+		return errUsernameInvalid
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return errUsernameReserved
+	}
+	return nil
+}
+
+// usernameTaken reports whether another user or account has the username,
+// names differing only in case are the same. Bots share their names and so
+// do the sessions of an account.
+func usernameTaken(repository IRepository, username string, except *User, accountID string) bool {
+	if account := repository.AccountByUsername(username); account != nil && account.id != accountID {
+		return true
+	}
+	for _, user := range repository.Users() {
+		if user == except || user.bot || (accountID != "" && user.account() == accountID) {
+			continue
+		}
+		if strings.EqualFold(user.name(), username) {
+			return true
+		}
+	}
+	return false
+}
+
+// login registers the user under the username of the Login payload, as a
+// guest or the player of the account authenticated by the payload or the
+// socket. Players of accounts keep the account name when there is none.
+func (u *User) login(payload map[string]string) error {
+	if u.repository.UserByUUID(u.uuid) != nil {
+		return errAlreadyLoggedIn
+	}
+	account, err := u.authenticate(payload)
+	if err != nil {
+		return err
+	}
+	username := payload["username"]
+	accountID := ""
+	if account != nil {
+		if _, ok := payload["password"]; ok || username == "" {
+			username = account.username
+		}
+		accountID = account.id
+	}
+	err = validateUsername(username)
+	if err != nil {
+		return err
+	}
+	usernamesMutex.Lock()
+	defer usernamesMutex.Unlock()
+	if usernameTaken(u.repository, username, u, accountID) {
+		return errUsernameTaken
+	}
+	u.mutex.Lock()
+	u.username = username
+	if account != nil {
+		u.accountID = account.id
+		u.rating = account.rating
+		if account.mutedUntil.After(u.mutedUntil) {
+			u.mutedUntil = account.mutedUntil
+		}
+		for _, identity := range account.blocked {
+			u.blocked[identity] = true
+		}
+	}
+	u.mutex.Unlock()
+	if u.reconnectToken == "" {
+		u.reconnectToken = generateToken()
+	}
+	u.repository.AddUser(u)
+	u.repository.SaveUser(u)
+	if account != nil {
+		updated := *account
+		updated.username = username
+		updated.mutedUntil = u.mutedTill(time.Now())
+		updated.blocked = u.blockedUsers()
+		u.repository.SaveAccount(&updated)
+	}
+	return nil
+}
+
+// rename changes the username of a logged in user
+func (u *User) rename(username string) error {
+	if u.repository.UserByUUID(u.uuid) == nil {
+		return errNotLoggedIn
+	}
+	err := validateUsername(username)
+	if err != nil {
+		return err
+	}
+	usernamesMutex.Lock()
+	defer usernamesMutex.Unlock()
+	if usernameTaken(u.repository, username, u, u.account()) {
+		return errUsernameTaken
+	}
+	u.setName(username)
+	u.repository.SaveUser(u)
+	u.saveAccount()
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 )
 
@@ -11,3 +12,25 @@ func generateUUID() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x",
 		b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
+
+// inviteCodeAlphabet leaves out characters that are easy to confuse
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const inviteCodeLength = 6
+
+// generateInviteCode returns a short code that is easy to read out loud
+func generateInviteCode() string {
+	b := make([]byte, inviteCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = inviteCodeAlphabet[int(b[i])%len(inviteCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// generateToken returns a random secret suitable for bearer style tokens
+func generateToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}